@@ -0,0 +1,239 @@
+package named_pipe_ipc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"log/slog"
+	"sync/atomic"
+
+	uuid2 "github.com/satori/go.uuid"
+)
+
+/**
+encrypted content:
+	12byte - string
+	nonce - AES-256-GCM of the content, sealed with type, uuid and ttl as additional data
+
+each side seals with its own key, derived from the secret, the uuid of the client and its role.
+The nonce is 4 random bytes drawn when the keys of a client are set up, followed by a counter of the frames
+sealed by this side. The content stays sealed until the frame reaches its client or the server,
+a frame sent back by another client is sent again as it is.
+*/
+
+const (
+	aeadFeature    = "aead/aes-256-gcm"
+	x25519Key      = "x25519"
+	aeadKeyInfo    = "named-pipe-ipc/aead/"
+	aeadPrefixLen  = 4
+	aeadCounterLen = 8
+)
+
+// WithEncryption seal the payloads with AES-256-GCM for the peers which agreed on it in the Handshake
+//
+// With a key, the key of each client is derived from it. Without, the client and the server exchange
+// X25519 keys in the Handshake. Frames of peers without encryption are unchanged.
+func WithEncryption(key []byte) Option {
+	return OptionsFunc(func(o *options) {
+		o.encryption = true
+		o.encryptionKey = key
+		o.features = append(o.features[:len(o.features):len(o.features)], aeadFeature)
+	})
+}
+
+// aeadState is the keys of a client and the nonces sealed by this side
+type aeadState struct {
+	seal    cipher.AEAD
+	open    cipher.AEAD
+	prefix  [aeadPrefixLen]byte
+	counter atomic.Uint64
+}
+
+func newAEADState(secret []byte, uuid uuid2.UUID, role RoleType) (*aeadState, error) {
+	peer := C
+	if role == C {
+		peer = S
+	}
+
+	state := &aeadState{}
+	var err error
+	if state.seal, err = newAEAD(secret, uuid, role); err != nil {
+		return nil, err
+	}
+	if state.open, err = newAEAD(secret, uuid, peer); err != nil {
+		return nil, err
+	}
+	if _, err = rand.Read(state.prefix[:]); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// newAEAD return the cipher of what role seals for the client uuid
+func newAEAD(secret []byte, uuid uuid2.UUID, role RoleType) (cipher.AEAD, error) {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(aeadKeyInfo + role.name() + "/"))
+	h.Write(uuid.Bytes())
+
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (s *aeadState) nonce() []byte {
+	nonce := make([]byte, 0, aeadPrefixLen+aeadCounterLen)
+	nonce = append(nonce, s.prefix[:]...)
+	return binary.BigEndian.AppendUint64(nonce, s.counter.Add(1))
+}
+
+// additionalData is what is sealed with the content without being encrypted, what does not change on the way
+func additionalData(frame Message) []byte {
+	ad := []byte{frame.segmentType()}
+	return append(ad, frame[frame.segmentUUIDOffset():frame.segmentHeaderLen()]...)
+}
+
+// aeadFor return the key of the client of frame, nil when the peer did not agree on encryption
+func (nctx *Context) aeadFor(frame Message) (*aeadState, error) {
+	if !nctx.peerCapabilities(frame).Has(aeadFeature) {
+		return nil, nil
+	}
+
+	uuid, err := frame.segmentUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	nctx.peerMu.Lock()
+	defer nctx.peerMu.Unlock()
+
+	if state, ok := nctx.aeads[uuid]; ok {
+		return state, nil
+	}
+	if nctx.options.encryptionKey == nil {
+		// the key exchange of the Handshake did not happen
+		return nil, CannotDecrypt{}
+	}
+
+	state, err := newAEADState(nctx.options.encryptionKey, uuid, nctx.role)
+	if err != nil {
+		return nil, err
+	}
+	nctx.aeads[uuid] = state
+
+	return state, nil
+}
+
+// encrypt seal the payload of frame when its peer agreed on encryption
+func (nctx *Context) encrypt(frame Message) (Message, error) {
+	if !nctx.options.encryption || frame.segmentType() == protoHelloType || frame.segmentFrameFlags()&frameFlagEncrypted != 0 {
+		return frame, nil
+	}
+
+	state, err := nctx.aeadFor(frame)
+	if err != nil || state == nil {
+		return frame, err
+	}
+
+	md, err := frame.segmentMetadata()
+	if err != nil {
+		return nil, err
+	}
+	nonce := state.nonce()
+	sealed := state.seal.Seal(nonce, nonce, frame.segmentPayload(), additionalData(frame))
+	m, err := frame.rebuild(md, sealed)
+	if err != nil {
+		return nil, err
+	}
+	m[m.segmentFrameFlagsOffset()] |= frameFlagEncrypted
+
+	return m, nil
+}
+
+// decrypt open the payload of an encrypted frame
+func (nctx *Context) decrypt(frame Message) (Message, error) {
+	// openFrame checked the frame, a frame which did not go through it is not sliced
+	if !frame.isWellFormed() {
+		return nil, MalformedFrame{Length: len(frame), Reason: "too short for its metadata"}
+	}
+	state, err := nctx.aeadFor(frame)
+	if err == nil && state == nil {
+		err = CannotDecrypt{}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := frame.segmentPayload()
+	nonceLen := aeadPrefixLen + aeadCounterLen
+	if len(sealed) < nonceLen {
+		return nil, CannotDecrypt{}
+	}
+	payload, err := state.open.Open(nil, sealed[:nonceLen], sealed[nonceLen:], additionalData(frame))
+	if err != nil {
+		return nil, CannotDecrypt{}
+	}
+
+	md, err := frame.segmentMetadata()
+	if err != nil {
+		return nil, err
+	}
+	m, err := frame.rebuild(md, payload)
+	if err != nil {
+		return nil, err
+	}
+	m[m.segmentFrameFlagsOffset()] &^= frameFlagEncrypted
+
+	return m, nil
+}
+
+// offerKeyExchange add the X25519 public key of a client to its hello, it returns the private key
+func (nctx *Context) offerKeyExchange(hello Metadata) (*ecdh.PrivateKey, error) {
+	if !nctx.options.encryption || nctx.options.encryptionKey != nil {
+		return nil, nil
+	}
+
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hello[x25519Key] = base64.StdEncoding.EncodeToString(private.PublicKey().Bytes())
+
+	return private, nil
+}
+
+// exchangeKey derive the key of uuid from our private key and the public key of the peer in headers
+func (nctx *Context) exchangeKey(uuid uuid2.UUID, private *ecdh.PrivateKey, headers Metadata) error {
+	b, err := base64.StdEncoding.DecodeString(headers[x25519Key])
+	if err != nil {
+		return err
+	}
+	public, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return err
+	}
+	shared, err := private.ECDH(public)
+	if err != nil {
+		return err
+	}
+
+	state, err := newAEADState(shared, uuid, nctx.role)
+	if err != nil {
+		return err
+	}
+
+	nctx.peerMu.Lock()
+	nctx.aeads[uuid] = state
+	nctx.peerMu.Unlock()
+
+	nctx.logger.Debug("key exchanged", slog.String("client", uuid.String()))
+	return nil
+}
@@ -0,0 +1,305 @@
+package named_pipe_ipc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"log/slog"
+	"strconv"
+	"time"
+
+	uuid2 "github.com/satori/go.uuid"
+)
+
+/**
+auth:
+	a client Connect with an auth frame, the server reply with an auth frame or an error frame.
+	The token is the content of the frame, so that it is encrypted with the payloads.
+
+	token:     client -> auth-method: token, content: token
+	           server -> subject, expires
+	key:       client -> auth-method: key
+	           server -> challenge
+	           client -> auth-method: key, public-key, signature, content: token
+	           server -> subject, expires
+
+	the signature is the ed25519 signature of the challenge followed by the uuid of the client.
+*/
+
+const (
+	authMethodKey    = "auth-method"
+	authPublicKeyKey = "public-key"
+	authSignatureKey = "signature"
+	authChallengeKey = "challenge"
+	authSubjectKey   = "subject"
+	authExpiresKey   = "expires"
+
+	authMethodToken = "token"
+	authMethodSign  = "key"
+
+	challengeLen = 32
+)
+
+// Credentials is what a client presents to Connect, a token and, to sign the challenge of the server, a key
+type Credentials struct {
+	Token string
+	Key   ed25519.PrivateKey
+}
+
+// AuthRequest is what the server knows of a client which Connect
+//
+// PublicKey is set when the client signed the challenge of the server with its private key.
+type AuthRequest struct {
+	Client    uuid2.UUID
+	Token     string
+	PublicKey ed25519.PublicKey
+}
+
+// Session is what the server granted to a client, Expires is zero for a session which does not expire
+type Session struct {
+	Subject string
+	Expires time.Time
+}
+
+func (s Session) expired() bool {
+	return !s.Expires.IsZero() && time.Now().After(s.Expires)
+}
+
+// Authenticator validate the credentials of the clients of a server
+//
+// It is called by Listen, a slow Authenticator holds the frames of every client.
+type Authenticator interface {
+	Authenticate(ctx context.Context, request AuthRequest) (Session, error)
+}
+
+type AuthenticatorFunc func(ctx context.Context, request AuthRequest) (Session, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, request AuthRequest) (Session, error) {
+	return f(ctx, request)
+}
+
+// Tokens is an Authenticator of static tokens, mapping a token to the subject of its session
+type Tokens map[string]string
+
+func (t Tokens) Authenticate(ctx context.Context, request AuthRequest) (Session, error) {
+	subject, ok := t[request.Token]
+	if !ok || request.Token == "" {
+		return Session{}, Unauthenticated{}
+	}
+
+	return Session{Subject: subject}, nil
+}
+
+// PublicKeys is an Authenticator of the clients which sign the challenge, mapping a subject to its key
+type PublicKeys map[string]ed25519.PublicKey
+
+func (p PublicKeys) Authenticate(ctx context.Context, request AuthRequest) (Session, error) {
+	for subject, key := range p {
+		if request.PublicKey != nil && key.Equal(request.PublicKey) {
+			return Session{Subject: subject}, nil
+		}
+	}
+
+	return Session{}, Unauthenticated{}
+}
+
+// WithAuthenticator make the server reject the frames of the clients which did not Connect
+//
+// The server needs WithEncryption, NewContext fails with InsecureAuthenticator without it. A client Handshakes
+// and agrees on encryption before it Connects: its session is bound to its keys, the frames of its uuid which
+// are not sealed with them are dropped. With a pre-shared key every holder of the key can seal for any client,
+// a key exchanged in the Handshake, WithEncryption(nil), is only known to its client. A UnixSocket pins the
+// uuid of a client to its connection, the server needs no encryption over it.
+func WithAuthenticator(authenticator Authenticator) Option {
+	return OptionsFunc(func(o *options) {
+		o.authenticator = authenticator
+	})
+}
+
+// Connect present credentials to the server and return the session it granted
+//
+// The client Handshakes with encryption first, the server only grants a session bound to its keys or,
+// over a UnixSocket, to its connection.
+// Like Handshake, the frames received before the reply of the server are dropped.
+func (nctx *Context) Connect(credentials Credentials) (Session, error) {
+	if nctx.role != C {
+		return Session{}, NotClientRole{}
+	}
+
+	metadata := Metadata{authMethodKey: authMethodToken}
+	if credentials.Key != nil {
+		challenge, err := nctx.authExchange(Metadata{authMethodKey: authMethodSign}, nil)
+		if err != nil {
+			return Session{}, err
+		}
+		b, err := base64.StdEncoding.DecodeString(challenge[authChallengeKey])
+		if err != nil || len(b) != challengeLen {
+			return Session{}, Unauthenticated{}
+		}
+
+		signed := append(b, nctx.clientID.Bytes()...)
+		metadata = Metadata{
+			authMethodKey:    authMethodSign,
+			authPublicKeyKey: base64.StdEncoding.EncodeToString(credentials.Key.Public().(ed25519.PublicKey)),
+			authSignatureKey: base64.StdEncoding.EncodeToString(ed25519.Sign(credentials.Key, signed)),
+		}
+	}
+
+	headers, err := nctx.authExchange(metadata, Message(credentials.Token))
+	if err != nil {
+		return Session{}, err
+	}
+
+	session := Session{Subject: headers[authSubjectKey]}
+	if expires, err := strconv.ParseInt(headers[authExpiresKey], 10, 64); err == nil {
+		session.Expires = time.Unix(expires, 0)
+	}
+
+	nctx.logger.Debug("connected", slog.String("subject", session.Subject))
+	return session, nil
+}
+
+// authExchange send an auth frame and return the headers of the auth frame of the server
+func (nctx *Context) authExchange(metadata Metadata, payload Message) (Metadata, error) {
+	frame, err := nctx.frame(protoAuthType, metadata, payload)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = nctx.directlySend(frame); err != nil {
+		return nil, err
+	}
+
+	for {
+		reply, err := nctx.recv(true)
+		if err != nil {
+			return nil, err
+		}
+		if reply.segmentType() != protoAuthType {
+			nctx.logger.Debug("frame dropped during connect", frameAttrs(reply)...)
+			continue
+		}
+
+		return reply.Headers(), nil
+	}
+}
+
+// login answer the auth frame of a client
+func (nctx *Context) login(frame Message) {
+	uuid, err := frame.segmentUUID()
+	if err != nil {
+		return
+	}
+	headers := frame.Headers()
+	if !verifiesPeers(nctx.transport) && !nctx.peerCapabilities(frame).Has(aeadFeature) {
+		// deliver dropped the unencrypted frames of the clients which agreed on encryption, this one did not,
+		// a transport which pins the uuid to a connection needs no keys
+		nctx.unauthenticated(frame, CannotDecrypt{})
+		return
+	}
+
+	request := AuthRequest{Client: uuid, Token: frame.Payload().String()}
+	if headers[authMethodKey] == authMethodSign {
+		if headers[authSignatureKey] == "" {
+			nctx.challenge(frame, uuid)
+			return
+		}
+		if request.PublicKey, err = nctx.verifyChallenge(uuid, headers); err != nil {
+			nctx.unauthenticated(frame, err)
+			return
+		}
+	}
+
+	session := Session{}
+	if nctx.options.authenticator != nil {
+		if session, err = nctx.options.authenticator.Authenticate(nctx.context, request); err != nil {
+			nctx.unauthenticated(frame, err)
+			return
+		}
+	}
+
+	nctx.peerMu.Lock()
+	nctx.sessions[uuid] = session
+	nctx.peerMu.Unlock()
+	nctx.logger.Debug("client authenticated", append(frameAttrs(frame), slog.String("subject", session.Subject))...)
+
+	metadata := Metadata{authSubjectKey: session.Subject}
+	if !session.Expires.IsZero() {
+		metadata[authExpiresKey] = strconv.FormatInt(session.Expires.Unix(), 10)
+	}
+	_, _ = nctx.Send(frame.reply(protoAuthType, nil, metadata))
+}
+
+// challenge send a client the challenge it signs with its key
+func (nctx *Context) challenge(frame Message, uuid uuid2.UUID) {
+	challenge := make([]byte, challengeLen)
+	if _, err := rand.Read(challenge); err != nil {
+		nctx.unauthenticated(frame, err)
+		return
+	}
+
+	nctx.peerMu.Lock()
+	nctx.challenges[uuid] = challenge
+	nctx.peerMu.Unlock()
+
+	_, _ = nctx.Send(frame.reply(protoAuthType, nil, Metadata{authChallengeKey: base64.StdEncoding.EncodeToString(challenge)}))
+}
+
+// verifyChallenge check the signature of the challenge sent to uuid, a challenge is only signed once
+func (nctx *Context) verifyChallenge(uuid uuid2.UUID, headers Metadata) (ed25519.PublicKey, error) {
+	nctx.peerMu.Lock()
+	challenge, ok := nctx.challenges[uuid]
+	delete(nctx.challenges, uuid)
+	nctx.peerMu.Unlock()
+	if !ok {
+		return nil, Unauthenticated{}
+	}
+
+	public, err := base64.StdEncoding.DecodeString(headers[authPublicKeyKey])
+	if err != nil || len(public) != ed25519.PublicKeySize {
+		return nil, Unauthenticated{}
+	}
+	signature, err := base64.StdEncoding.DecodeString(headers[authSignatureKey])
+	if err != nil || !ed25519.Verify(public, append(challenge, uuid.Bytes()...), signature) {
+		return nil, Unauthenticated{}
+	}
+
+	return public, nil
+}
+
+func (nctx *Context) unauthenticated(frame Message, err error) {
+	nctx.stats.unauthenticated.Add(1)
+	nctx.logger.Warn("client not authenticated", append(frameAttrs(frame), slog.Any("error", err))...)
+	_, _ = nctx.Send(frame.ErrorPayload(Unauthenticated{}))
+}
+
+// hasSession tell if the client of frame may send, always when the server has no Authenticator
+func (nctx *Context) hasSession(frame Message) bool {
+	if nctx.options.authenticator == nil {
+		return true
+	}
+
+	session, ok := nctx.session(frame)
+	return ok && !session.expired()
+}
+
+func (nctx *Context) session(frame Message) (Session, bool) {
+	uuid, err := frame.segmentUUID()
+	if err != nil {
+		return Session{}, false
+	}
+
+	nctx.peerMu.RLock()
+	defer nctx.peerMu.RUnlock()
+	session, ok := nctx.sessions[uuid]
+
+	return session, ok
+}
+
+type sessionContextKey struct{}
+
+// SessionFromContext return the session of the client of the frame a handler is serving
+func SessionFromContext(ctx context.Context) (Session, bool) {
+	session, ok := ctx.Value(sessionContextKey{}).(Session)
+	return session, ok
+}
@@ -0,0 +1,280 @@
+package named_pipe_ipc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+
+	uuid2 "github.com/satori/go.uuid"
+)
+
+// Bridge relay the frames of network connections to the server of a chroot, for debugging
+//
+// Every connection gets its own client Context, with the uuid of the first frame of the connection,
+// so that the replies of the server go back to it. The Bridge is a hop: it checks and adds the checksums
+// and the MACs of its options, encrypted payloads are relayed as they are.
+type Bridge struct {
+	ctx    context.Context
+	chroot string
+	opts   []Option
+	// logger is the logger of the options, for the connections which got no client Context
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	clients   map[uuid2.UUID]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewBridge return a Bridge to the server under chroot, opts are the options of its client Contexts
+func NewBridge(ctx context.Context, chroot string, opts ...Option) *Bridge {
+	o := *defaultOption
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+
+	return &Bridge{
+		ctx:       ctx,
+		chroot:    chroot,
+		opts:      opts,
+		logger:    o.logger,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		clients:   make(map[uuid2.UUID]struct{}),
+	}
+}
+
+// ListenAndServe listen on the "tcp" or "unix" address and Serve it
+func (b *Bridge) ListenAndServe(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+
+	return b.Serve(l)
+}
+
+// Serve relay the connections of l until l or the Bridge is closed
+func (b *Bridge) Serve(l net.Listener) error {
+	b.mu.Lock()
+	b.listeners[l] = struct{}{}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.listeners, l)
+		b.mu.Unlock()
+	}()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-b.ctx.Done():
+			l.Close()
+		case <-stop:
+		}
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		b.mu.Lock()
+		b.conns[conn] = struct{}{}
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.relay(conn)
+		}()
+	}
+}
+
+// Close close the listeners and the connections, and wait for the client Contexts to be closed
+func (b *Bridge) Close() error {
+	b.mu.Lock()
+	for l := range b.listeners {
+		l.Close()
+	}
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return nil
+}
+
+// relay the frames of conn through a client Context until one of them is closed
+func (b *Bridge) relay(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+	}()
+
+	nctx, err := NewContext(b.ctx, b.chroot, C, b.opts...)
+	if err != nil {
+		// the connection is closed without a frame, the remote client tells it from a reply it never gets
+		b.logger.Warn("bridge connection closed, no client Context", slog.String("remote", conn.RemoteAddr().String()), slog.Any("error", err))
+		return
+	}
+	// the FIFOs belong to the server, the client Context only closes them
+	defer nctx.close()
+
+	remote := nctx.newDecoder(conn)
+	first, err := nctx.readRemote(remote)
+	if err != nil {
+		return
+	}
+
+	uuid, _ := first.segmentUUID()
+	b.mu.Lock()
+	_, taken := b.clients[uuid]
+	if !taken {
+		b.clients[uuid] = struct{}{}
+	}
+	b.mu.Unlock()
+	if taken {
+		nctx.logger.Warn("bridge connection of a client already connected", slog.String("client", uuid.String()), slog.String("remote", conn.RemoteAddr().String()))
+		return
+	}
+	defer func() {
+		b.mu.Lock()
+		delete(b.clients, uuid)
+		b.mu.Unlock()
+	}()
+
+	nctx.clientID = uuid
+	nctx.logger.Debug("bridge connection", slog.String("client", uuid.String()), slog.String("remote", conn.RemoteAddr().String()))
+
+	var wmu sync.Mutex
+	toRemote := func(frame Message) error {
+		frame, err := nctx.sealFrame(frame)
+		if err != nil {
+			return err
+		}
+		wmu.Lock()
+		defer wmu.Unlock()
+		_, err = conn.Write(append(frame, nctx.delim))
+		return err
+	}
+
+	// the replies of the server
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer conn.Close()
+		for {
+			frame, err := nctx.readOwnFrame()
+			if err != nil {
+				return
+			}
+			if err = toRemote(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	for frame := first; ; {
+		if id, _ := frame.segmentUUID(); id != uuid {
+			nctx.logger.Warn("bridge frame of another client dropped", frameAttrs(frame)...)
+		} else if _, err = nctx.directlySend(frame); err != nil {
+			if _, ok := err.(TooLarge); ok {
+				err = toRemote(frame.ErrorPayload(err))
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		if frame, err = nctx.readRemote(remote); err != nil {
+			break
+		}
+	}
+
+	nctx.close()
+	<-done
+}
+
+// readRemote return the next frame of a remote connection of a Bridge which opens
+func (nctx *Context) readRemote(d *decoder) (Message, error) {
+	for {
+		frame, err := d.next()
+		if _, ok := err.(frameTooLarge); ok {
+			nctx.stats.tooLarge.Add(1)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		nctx.stats.received(frame)
+
+		if !frame.isCompatible() {
+			nctx.incompatible(frame)
+			continue
+		}
+		if frame, err = nctx.openFrame(frame); err != nil {
+			continue
+		}
+
+		return frame, nil
+	}
+}
+
+// Dial return a Transport for a client over a connection to the network address, such as a Bridge
+func Dial(network, address string) Transport {
+	return &dialTransport{network: network, address: address}
+}
+
+type dialTransport struct {
+	network string
+	address string
+	conn    net.Conn
+	decoder *decoder
+}
+
+func (t *dialTransport) Open(nctx *Context) error {
+	if nctx.role != C {
+		return NotClientRole{}
+	}
+
+	conn, err := net.Dial(t.network, t.address)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	t.decoder = nctx.newDecoder(conn)
+
+	nctx.logger.Debug("connected", slog.String("network", t.network), slog.String("address", t.address))
+	return nil
+}
+
+func (t *dialTransport) Read() (Message, Peer, error) {
+	frame, err := t.decoder.next()
+	return frame, unknownPeer, err
+}
+
+func (t *dialTransport) Write(frame []byte) error {
+	_, err := t.conn.Write(frame)
+	return err
+}
+
+func (t *dialTransport) Close() error {
+	err := t.conn.Close()
+	if isClosed(err) {
+		return nil
+	}
+
+	return err
+}
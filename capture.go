@@ -0,0 +1,396 @@
+package named_pipe_ipc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	uuid2 "github.com/satori/go.uuid"
+)
+
+/**
+capture, written by a Context with WithRecorder:
+	8byte - 1byte - 1byte - 1byte
+	magic "NPIPECAP" - version - role 'C' or 'S' - delim
+
+then a record for every frame the Context sent or received, as it was on the wire:
+	8byte - 1byte - 4byte - frameLength
+	unix nano - direction '>' sent or '<' received - frameLength - frame without the delim
+
+A reader stops at the header of a version it does not know, the records of a version keep their layout.
+*/
+
+const (
+	captureMagic                = "NPIPECAP"
+	captureVersion         byte = 1
+	captureHeaderLen            = 11
+	captureRecordHeaderLen      = 13
+)
+
+// Direction tell if a Record is a frame sent or received by the Context which recorded it
+type Direction byte
+
+const (
+	RecordSent     Direction = '>'
+	RecordReceived Direction = '<'
+)
+
+// Record is a frame of a capture
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	// Frame is the frame as it was on the wire, sealed, without the delim
+	Frame Message
+}
+
+// WithRecorder write every frame the Context sends and receives to w, in the capture format
+//
+// The frames are recorded as they are on the wire, so encrypted frames can not be replayed.
+// A write error stops the recording and is logged, the Context keeps running.
+func WithRecorder(w io.Writer) Option {
+	return OptionsFunc(func(o *options) {
+		o.recorder = w
+	})
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	failed bool
+}
+
+// newRecorder write the header of the capture to w
+func newRecorder(w io.Writer, role RoleType, delim byte) (*recorder, error) {
+	header := append([]byte(captureMagic), captureVersion, roleByte(role), delim)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &recorder{w: w}, nil
+}
+
+// record write frame to the capture of the Context, if it has one
+func (nctx *Context) record(direction Direction, frame Message) {
+	r := nctx.recorder
+	if r == nil {
+		return
+	}
+
+	buf := make([]byte, captureRecordHeaderLen, captureRecordHeaderLen+len(frame))
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+	buf[8] = byte(direction)
+	binary.BigEndian.PutUint32(buf[9:], uint32(len(frame)))
+	buf = append(buf, frame...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed {
+		return
+	}
+	if _, err := r.w.Write(buf); err != nil {
+		r.failed = true
+		nctx.logger.Warn("recording stopped", slog.Any("error", err))
+	}
+}
+
+func roleByte(role RoleType) byte {
+	if role == S {
+		return 'S'
+	}
+	return 'C'
+}
+
+// CaptureReader read the records of a capture one at a time
+type CaptureReader struct {
+	r      *bufio.Reader
+	offset int64
+	// broken is set once the records can not be told apart
+	broken bool
+
+	Version byte
+	Role    RoleType
+	Delim   byte
+}
+
+// NewCaptureReader read the header of the capture r
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	cr := &CaptureReader{r: bufio.NewReader(r), offset: captureHeaderLen}
+
+	header := make([]byte, captureHeaderLen)
+	if _, err := io.ReadFull(cr.r, header); err != nil || string(header[:len(captureMagic)]) != captureMagic {
+		return nil, NotCapture{}
+	}
+	if cr.Version = header[8]; cr.Version != captureVersion {
+		return nil, UnsupportedCaptureVersion{}
+	}
+	cr.Role = C
+	if header[9] == 'S' {
+		cr.Role = S
+	}
+	cr.Delim = header[10]
+
+	return cr, nil
+}
+
+// IsCapture tell if b begins like a capture
+func IsCapture(b []byte) bool {
+	return len(b) >= len(captureMagic) && string(b[:len(captureMagic)]) == captureMagic
+}
+
+// Next return the next record of the capture and its offset, io.EOF at the end
+//
+// A record whose frame is not well formed is returned with a MalformedFrame. A cut or unknown record is a
+// MalformedFrame too, the records after it can not be found and Next returns io.EOF.
+func (cr *CaptureReader) Next() (Record, int64, error) {
+	offset := cr.offset
+	if cr.broken {
+		return Record{}, offset, io.EOF
+	}
+	header := make([]byte, captureRecordHeaderLen)
+	if n, err := io.ReadFull(cr.r, header); err != nil {
+		if err == io.EOF {
+			return Record{}, offset, io.EOF
+		}
+		cr.broken = true
+		return Record{}, offset, MalformedFrame{Offset: offset, Length: n, Reason: "truncated record"}
+	}
+
+	record := Record{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(header))),
+		Direction: Direction(header[8]),
+	}
+	length := int64(binary.BigEndian.Uint32(header[9:]))
+	if (record.Direction != RecordSent && record.Direction != RecordReceived) || length > defaultMaxFrameLength {
+		cr.broken = true
+		return record, offset, MalformedFrame{Offset: offset, Length: captureRecordHeaderLen, Reason: "bad record"}
+	}
+
+	record.Frame = make(Message, length)
+	if n, err := io.ReadFull(cr.r, record.Frame); err != nil {
+		cr.broken = true
+		return record, offset, MalformedFrame{Offset: offset, Length: captureRecordHeaderLen + n, Reason: "truncated record"}
+	}
+	cr.offset += captureRecordHeaderLen + length
+
+	if length < minFrameLength()-1 || !record.Frame.isLegal() || !record.Frame.isWellFormed() {
+		return record, offset, MalformedFrame{Offset: offset, Length: int(captureRecordHeaderLen + length), Reason: "malformed frame"}
+	}
+
+	return record, offset, nil
+}
+
+// Capture is a capture read in memory, to replay it
+type Capture struct {
+	Role    RoleType
+	Delim   byte
+	Records []Record
+}
+
+// ReadCapture read the whole capture r, the malformed records are left out
+func ReadCapture(r io.Reader) (*Capture, error) {
+	cr, err := NewCaptureReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Capture{Role: cr.Role, Delim: cr.Delim}
+	for {
+		record, _, err := cr.Next()
+		if err == io.EOF {
+			return c, nil
+		}
+		if _, ok := err.(MalformedFrame); ok {
+			continue
+		}
+		c.Records = append(c.Records, record)
+	}
+}
+
+// exchange is a request of the capture and the reply it got, if any
+type exchange struct {
+	at      time.Time
+	request Message
+	reply   Message
+	// delay is how long the reply took
+	delay time.Duration
+}
+
+// exchanges pair the requests of the clients with the replies of the server, in the order of the requests
+//
+// The requests are the normal frames sent to the server, and a reply is the next response or error frame
+// to the same client. The handshakes and the retransmissions are left out.
+func (c *Capture) exchanges() ([]*exchange, error) {
+	var exchanges []*exchange
+	pending := make(map[uuid2.UUID][]*exchange)
+
+	for _, record := range c.Records {
+		frame := record.Frame
+		if frame.isRetran() {
+			continue
+		}
+		uuid, _ := frame.segmentUUID()
+		toServer := (c.Role == C) == (record.Direction == RecordSent)
+
+		switch t := frame.segmentType(); {
+		case toServer && t == protoNormalType:
+			request, err := unseal(frame)
+			if err != nil {
+				return nil, err
+			}
+			e := &exchange{at: record.Time, request: request}
+			exchanges = append(exchanges, e)
+			pending[uuid] = append(pending[uuid], e)
+		case !toServer && (t == protoResponseType || t == protoErrorType) && len(pending[uuid]) > 0:
+			reply, err := unseal(frame)
+			if err != nil {
+				return nil, err
+			}
+			e := pending[uuid][0]
+			pending[uuid] = pending[uuid][1:]
+			e.reply, e.delay = reply, record.Time.Sub(e.at)
+		}
+	}
+
+	return exchanges, nil
+}
+
+// unseal remove what protects a recorded frame on the wire and decompress it
+func unseal(frame Message) (Message, error) {
+	if frame.segmentFrameFlags()&frameFlagEncrypted != 0 {
+		return nil, CannotDecrypt{}
+	}
+	if frame.segmentFrameFlags()&frameFlagChecksum != 0 {
+		var err error
+		if frame, err = verifyChecksum(frame); err != nil {
+			return nil, err
+		}
+	}
+	if frame.segmentFrameFlags()&frameFlagMAC != 0 {
+		var err error
+		if frame, err = openTrailer(frame, frameFlagMAC, macNonceLen+macLen); err != nil {
+			return nil, err
+		}
+	}
+	if frame.segmentFrameFlags()&frameFlagCompressed != 0 {
+		return decompressFrame(frame, builtinCompressors, defaultMaxFrameLength)
+	}
+
+	return frame, nil
+}
+
+// ReplayResult is a request sent again by Capture.Replay
+type ReplayResult struct {
+	Request Message
+	// Recorded is the reply in the capture, nil if the request got none
+	Recorded Message
+	// Reply is the payload Call returned and Err its error, both nil for a request sent without waiting
+	Reply Message
+	Err   error
+}
+
+// Replay send the requests of the capture through client, at the pace of the capture divided by speed
+//
+// A speed of 0 sends them without waiting. The requests of every client of the capture go through client in order,
+// with their headers. A request which got a reply in the capture is sent with Call, the others with Send.
+// It stops at the first error which is not a reply of the server.
+func (c *Capture) Replay(ctx context.Context, client *Context, speed float64) ([]ReplayResult, error) {
+	if client.role != C {
+		return nil, NotClientRole{}
+	}
+	exchanges, err := c.exchanges()
+	if err != nil {
+		return nil, err
+	}
+
+	var replayed []ReplayResult
+	start := time.Now()
+	for _, e := range exchanges {
+		if speed > 0 {
+			wait := time.Duration(float64(e.at.Sub(exchanges[0].at))/speed) - time.Since(start)
+			if err = sleepContext(ctx, wait); err != nil {
+				return replayed, err
+			}
+		} else if err = ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		r := ReplayResult{Request: e.request.Payload(), Recorded: e.reply}
+		if r.Recorded != nil {
+			r.Recorded = r.Recorded.Payload()
+		}
+		hctx := ContextWithHeaders(ctx, e.request.Headers())
+		if e.reply == nil {
+			if _, err = client.SendContext(hctx, r.Request); err != nil {
+				return replayed, err
+			}
+		} else if r.Reply, r.Err = client.CallContext(hctx, r.Request); r.Reply == nil && r.Err != nil {
+			return replayed, r.Err
+		} else {
+			r.Reply = r.Reply.Payload()
+		}
+		replayed = append(replayed, r)
+	}
+
+	return replayed, nil
+}
+
+// Handler return a Handler which answers the requests with the replies of the capture in order, each after
+// the delay it had in the capture divided by speed
+//
+// A recorded error frame is answered as an error. Once the replies are used up it answers CaptureExhausted.
+func (c *Capture) Handler(speed float64) (Handler, error) {
+	exchanges, err := c.exchanges()
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var replies []*exchange
+	for _, e := range exchanges {
+		if e.reply != nil {
+			replies = append(replies, e)
+		}
+	}
+
+	return HandlerFunc(func(ctx context.Context, message Message) (Message, error) {
+		mu.Lock()
+		if len(replies) == 0 {
+			mu.Unlock()
+			return nil, CaptureExhausted{}
+		}
+		e := replies[0]
+		replies = replies[1:]
+		mu.Unlock()
+
+		if speed > 0 {
+			if err := sleepContext(ctx, time.Duration(float64(e.delay)/speed)); err != nil {
+				return nil, err
+			}
+		}
+		if e.reply.isError() {
+			return nil, RemoteError{Message: e.reply.Payload().String()}
+		}
+
+		return e.reply.Payload(), nil
+	}), nil
+}
+
+// sleepContext wait for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
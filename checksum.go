@@ -0,0 +1,47 @@
+package named_pipe_ipc
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+/**
+checksum, after the content:
+	4byte
+	CRC32C of the frame from byteLength to the end of the content, with frameFlagChecksum set
+*/
+
+const checksumLen = 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// WithChecksum make every frame sent carry a CRC32C checksum
+//
+// The frames with a checksum are verified whatever the option of the reader,
+// a frame which fails is dropped and counted in Stats.ChecksumMismatch.
+func WithChecksum() Option {
+	return OptionsFunc(func(o *options) {
+		o.checksum = true
+	})
+}
+
+func appendChecksum(frame Message) Message {
+	m := withTrailer(frame, frameFlagChecksum, make([]byte, checksumLen))
+	binary.BigEndian.PutUint32(m[len(m)-checksumLen:], crc32.Checksum(m[:len(m)-checksumLen], castagnoli))
+
+	return m
+}
+
+func verifyChecksum(frame Message) (Message, error) {
+	if len(frame) < frame.segmentHeaderLen()+checksumLen {
+		return frame, ChecksumMismatch{}
+	}
+
+	sum := binary.BigEndian.Uint32(frame[len(frame)-checksumLen:])
+	if crc32.Checksum(frame[:len(frame)-checksumLen], castagnoli) != sum {
+		return frame, ChecksumMismatch{}
+	}
+
+	// the checksum may be right and what is left not be a frame
+	return openTrailer(frame, frameFlagChecksum, checksumLen)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// benchResult is what a client measured, a worker process prints it as JSON
+type benchResult struct {
+	Sent            int     `json:"sent"`
+	Received        int     `json:"received"`
+	Errors          int     `json:"errors"`
+	Lost            int     `json:"lost"`
+	Misrouted       int     `json:"misrouted"`
+	Retransmissions uint64  `json:"retransmissions"`
+	Latencies       []int64 `json:"latencies"`
+}
+
+func (r *benchResult) add(o benchResult) {
+	r.Sent += o.Sent
+	r.Received += o.Received
+	r.Errors += o.Errors
+	r.Lost += o.Lost
+	r.Misrouted += o.Misrouted
+	r.Retransmissions += o.Retransmissions
+	r.Latencies = append(r.Latencies, o.Latencies...)
+}
+
+// benchRow is a line of the report, for a payload size
+type benchRow struct {
+	Size            int     `json:"size"`
+	Clients         int     `json:"clients"`
+	Processes       bool    `json:"processes"`
+	Sent            int     `json:"sent"`
+	Received        int     `json:"received"`
+	Errors          int     `json:"errors"`
+	Lost            int     `json:"lost"`
+	Misrouted       int     `json:"misrouted"`
+	Retransmissions uint64  `json:"retransmissions"`
+	Seconds         float64 `json:"seconds"`
+	Throughput      float64 `json:"throughput"`
+	MBps            float64 `json:"mbps"`
+	P50             float64 `json:"p50_ms"`
+	P99             float64 `json:"p99_ms"`
+}
+
+func bench(ctx context.Context, c *config, args []string) error {
+	var sizes []int
+	for _, s := range strings.Split(c.sizes, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || size < 0 {
+			return fmt.Errorf("bad payload size %q", s)
+		}
+		sizes = append(sizes, size)
+	}
+
+	if c.worker {
+		r, client, err := benchClient(ctx, c, strconv.Itoa(os.Getpid()), sizes[0])
+		if err != nil {
+			return err
+		}
+		client.Close()
+		return json.NewEncoder(c.stdout).Encode(r)
+	}
+
+	var rows []benchRow
+	for _, size := range sizes {
+		row, err := benchRound(ctx, c, size)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return c.report(rows)
+}
+
+// benchRound run the clients against a server with payloads of size bytes
+func benchRound(ctx context.Context, c *config, size int) (benchRow, error) {
+	rc := *c
+	if !c.external {
+		chroot, err := os.MkdirTemp("", "npipe-bench")
+		if err != nil {
+			return benchRow{}, err
+		}
+		defer os.RemoveAll(chroot)
+		rc.chroot = chroot
+
+		sctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		server, err := rc.context(sctx, named_pipe_ipc.S)
+		if err != nil {
+			return benchRow{}, err
+		}
+		defer server.Close()
+		go server.Serve(named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+			return message.Payload(), nil
+		}), named_pipe_ipc.WithWorkers(c.workers))
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		total   benchResult
+		clients []*named_pipe_ipc.Context
+		errs    []error
+	)
+	start := time.Now()
+	for i := 0; i < c.clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var (
+				r      benchResult
+				client *named_pipe_ipc.Context
+				err    error
+			)
+			if c.procs {
+				r, err = benchProcess(ctx, &rc, size)
+			} else {
+				r, client, err = benchClient(ctx, &rc, strconv.Itoa(i), size)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			total.add(r)
+			if client != nil {
+				clients = append(clients, client)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	for _, client := range clients {
+		client.Close()
+	}
+	if len(errs) > 0 {
+		return benchRow{}, errs[0]
+	}
+
+	sort.Slice(total.Latencies, func(i, j int) bool { return total.Latencies[i] < total.Latencies[j] })
+	return benchRow{
+		Size:            size,
+		Clients:         c.clients,
+		Processes:       c.procs,
+		Sent:            total.Sent,
+		Received:        total.Received,
+		Errors:          total.Errors,
+		Lost:            total.Lost,
+		Misrouted:       total.Misrouted,
+		Retransmissions: total.Retransmissions,
+		Seconds:         elapsed.Seconds(),
+		Throughput:      float64(total.Received) / elapsed.Seconds(),
+		MBps:            float64(2*total.Received*size) / elapsed.Seconds() / 1e6,
+		P50:             percentile(total.Latencies, 0.50),
+		P99:             percentile(total.Latencies, 0.99),
+	}, nil
+}
+
+// benchClient Call the server -requests times with payloads of size bytes which tell the client and the request apart
+//
+// A request without a reply after -wait is lost, the client is closed and a new one goes on. It returns the client
+// left open, or the error which kept it from opening one.
+func benchClient(ctx context.Context, c *config, tag string, size int) (benchResult, *named_pipe_ipc.Context, error) {
+	var r benchResult
+	var cancels []context.CancelFunc
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+	open := func() (*named_pipe_ipc.Context, error) {
+		cctx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		return c.context(cctx, named_pipe_ipc.C)
+	}
+
+	client, err := open()
+	if err != nil {
+		return r, nil, err
+	}
+
+	type reply struct {
+		message named_pipe_ipc.Message
+		err     error
+	}
+	for i := 0; i < c.requests && ctx.Err() == nil; i++ {
+		payload := benchPayload(tag, i, size)
+		done := make(chan reply, 1)
+		start := time.Now()
+		go func() {
+			message, err := client.Call(payload)
+			done <- reply{message, err}
+		}()
+
+		r.Sent++
+		timer := time.NewTimer(c.wait)
+		select {
+		case rep := <-done:
+			timer.Stop()
+			if rep.err != nil {
+				r.Errors++
+				if rep.message == nil {
+					i = c.requests
+				}
+				continue
+			}
+			r.Received++
+			r.Latencies = append(r.Latencies, int64(time.Since(start)))
+			if !bytes.Equal(rep.message.Payload(), payload) {
+				r.Misrouted++
+			}
+		case <-timer.C:
+			r.Lost++
+			cancels[len(cancels)-1]()
+			<-done
+			r.Retransmissions += client.Stats().Retransmissions
+
+			if client, err = open(); err != nil {
+				return r, nil, err
+			}
+		}
+	}
+	r.Retransmissions += client.Stats().Retransmissions
+
+	return r, client, nil
+}
+
+// benchProcess run a client in a worker process
+func benchProcess(ctx context.Context, c *config, size int) (benchResult, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return benchResult{}, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, "bench", "-worker",
+		"-chroot", c.chroot, "-read", c.read, "-write", c.write, "-delim", c.delim, "-ttl", c.ttl.String(),
+		"-requests", strconv.Itoa(c.requests), "-sizes", strconv.Itoa(size), "-wait", c.wait.String())
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		return benchResult{}, fmt.Errorf("worker: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var r benchResult
+	if err = json.Unmarshal(stdout.Bytes(), &r); err != nil {
+		return benchResult{}, errors.New("worker: " + err.Error())
+	}
+
+	return r, nil
+}
+
+// benchPayload is "<tag>-<i>|" padded to size bytes
+func benchPayload(tag string, i, size int) named_pipe_ipc.Message {
+	payload := []byte(tag + "-" + strconv.Itoa(i) + "|")
+	for len(payload) < size {
+		payload = append(payload, 'x')
+	}
+
+	return payload
+}
+
+// percentile return the latency in milliseconds under which q of the sorted latencies are
+func percentile(sorted []int64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(q*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+
+	return float64(sorted[i]) / 1e6
+}
+
+func (c *config) report(rows []benchRow) error {
+	if c.json {
+		enc := json.NewEncoder(c.stdout)
+		for _, row := range rows {
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "size\tclients\tsent\treceived\terrors\tlost\tmisrouted\tretransmitted\treq/s\tMB/s\tp50 ms\tp99 ms\t")
+	for _, r := range rows {
+		clients := strconv.Itoa(r.Clients)
+		if r.Processes {
+			clients += "p"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%.0f\t%.2f\t%.3f\t%.3f\t\n",
+			r.Size, clients, r.Sent, r.Received, r.Errors, r.Lost, r.Misrouted, r.Retransmissions, r.Throughput, r.MBps, r.P50, r.P99)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// payload return the arguments, or the content of -file, or stdin
+func (c *config) payload(args []string) ([]byte, error) {
+	switch {
+	case len(args) > 0 && !(len(args) == 1 && args[0] == "-"):
+		return []byte(strings.Join(args, " ")), nil
+	case c.file != "" && c.file != "-":
+		return os.ReadFile(c.file)
+	default:
+		return io.ReadAll(c.stdin)
+	}
+}
+
+func send(ctx context.Context, c *config, args []string) error {
+	payload, err := c.payload(args)
+	if err != nil {
+		return err
+	}
+	nctx, err := c.context(ctx, named_pipe_ipc.C)
+	if err != nil {
+		return err
+	}
+
+	_, err = nctx.Send(named_pipe_ipc.Message(payload))
+	return err
+}
+
+func call(ctx context.Context, c *config, args []string) error {
+	payload, err := c.payload(args)
+	if err != nil {
+		return err
+	}
+	nctx, err := c.context(ctx, named_pipe_ipc.C)
+	if err != nil {
+		return err
+	}
+
+	reply, err := nctx.Call(named_pipe_ipc.Message(payload))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(c.stdout, reply.Payload().String())
+	return err
+}
+
+func listen(ctx context.Context, c *config, args []string) error {
+	nctx, err := c.context(ctx, named_pipe_ipc.S)
+	if err != nil {
+		return err
+	}
+	defer nctx.Close()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- nctx.Listen()
+	}()
+
+	for {
+		message, err := nctx.Recv(true)
+		if err != nil {
+			if _, ok := err.(named_pipe_ipc.Closed); ok {
+				return <-listenErr
+			}
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return nil
+			}
+			return err
+		}
+
+		if _, err = fmt.Fprintln(c.stdout, message.Payload().String()); err != nil {
+			return err
+		}
+	}
+}
+
+func serve(ctx context.Context, c *config, args []string) error {
+	if c.exec == "" {
+		return errors.New("--exec is required")
+	}
+	nctx, err := c.context(ctx, named_pipe_ipc.S)
+	if err != nil {
+		return err
+	}
+	defer nctx.Close()
+
+	handler := named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", c.exec)
+		cmd.Stdin = bytes.NewReader(message.Payload())
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		for k, v := range message.Headers() {
+			cmd.Env = append(cmd.Env, "NPIPE_HEADER_"+headerEnv(k)+"="+v)
+		}
+		cmd.Env = append(os.Environ(), cmd.Env...)
+
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, errors.New(msg)
+			}
+			return nil, err
+		}
+
+		return named_pipe_ipc.Message(bytes.TrimSuffix(stdout.Bytes(), []byte("\n"))), nil
+	})
+
+	err = nctx.Serve(handler, named_pipe_ipc.WithWorkers(c.workers))
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+
+	return err
+}
+
+// headerEnv turn a header key into the end of the name of an environment variable
+func headerEnv(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
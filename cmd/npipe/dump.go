@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// dumpRecord is a line of npipe dump, a frame or the bytes which are not one
+type dumpRecord struct {
+	Time      string            `json:"time,omitempty"`
+	Direction string            `json:"direction,omitempty"`
+	Source    string            `json:"source,omitempty"`
+	Offset    int64             `json:"offset"`
+	Length    int64             `json:"length"`
+	Malformed string            `json:"malformed,omitempty"`
+	Flag      string            `json:"flag,omitempty"`
+	Version   int               `json:"version,omitempty"`
+	Flags     []string          `json:"flags,omitempty"`
+	Type      string            `json:"type,omitempty"`
+	TypeName  string            `json:"type_name,omitempty"`
+	Client    string            `json:"client,omitempty"`
+	TTL       string            `json:"ttl,omitempty"`
+	Expired   bool              `json:"expired,omitempty"`
+	Problem   string            `json:"problem,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Payload   string            `json:"payload,omitempty"`
+	Encoding  string            `json:"encoding,omitempty"`
+	Size      int               `json:"size,omitempty"`
+	Truncated bool              `json:"truncated,omitempty"`
+}
+
+// dumper print the records of one or more streams, one line at a time
+type dumper struct {
+	c     *config
+	delim byte
+	mu    sync.Mutex
+}
+
+func dump(ctx context.Context, c *config, args []string) error {
+	delim, err := c.delimiter()
+	if err != nil {
+		return err
+	}
+	d := &dumper{c: c, delim: delim}
+
+	if c.proxy != "" {
+		return d.proxy(ctx)
+	}
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-") {
+		return d.input(c.stdin, "")
+	}
+	for _, name := range args {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = d.input(f, name)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// input print a capture or a raw stream, whichever r is
+func (d *dumper) input(r io.Reader, source string) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(8); named_pipe_ipc.IsCapture(magic) {
+		return d.capture(br, source)
+	}
+
+	return d.stream(br, source, "")
+}
+
+// capture print the records of a capture, a frame is expired if it was at the time it was recorded
+func (d *dumper) capture(r io.Reader, source string) error {
+	cr, err := named_pipe_ipc.NewCaptureReader(r)
+	if err != nil {
+		return err
+	}
+
+	for {
+		record, offset, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		r := dumpRecord{Source: source, Offset: offset, Direction: "sent"}
+		if record.Direction == named_pipe_ipc.RecordReceived {
+			r.Direction = "received"
+		}
+		if !record.Time.IsZero() {
+			r.Time = record.Time.Format(time.RFC3339Nano)
+		}
+
+		var malformed named_pipe_ipc.MalformedFrame
+		if errors.As(err, &malformed) {
+			r.Length = int64(malformed.Length)
+			r.Malformed = malformed.Reason
+		} else if err != nil {
+			return err
+		} else {
+			d.describe(&r, named_pipe_ipc.Inspect(record.Frame), record.Time)
+		}
+
+		if err = d.print(r); err != nil {
+			return err
+		}
+	}
+}
+
+// stream print the frames of r until its end, the frames of a relay are timed when they are read
+func (d *dumper) stream(r io.Reader, source, direction string) error {
+	decoder := named_pipe_ipc.NewDecoder(r, d.delim)
+	for {
+		frame, offset, err := decoder.Next()
+		record := dumpRecord{Direction: direction, Source: source, Offset: offset}
+		if direction != "" {
+			record.Time = time.Now().Format(time.RFC3339Nano)
+		}
+
+		var malformed named_pipe_ipc.MalformedFrame
+		switch {
+		case errors.As(err, &malformed):
+			record.Length = int64(malformed.Length)
+			record.Malformed = malformed.Reason
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		default:
+			d.describe(&record, named_pipe_ipc.Inspect(frame), time.Now())
+		}
+
+		if err = d.print(record); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *dumper) describe(record *dumpRecord, fi named_pipe_ipc.FrameInfo, now time.Time) {
+	record.Length = fi.Length
+	record.Flag = "named-pipe-ipc"
+	record.Version = int(fi.Version)
+	record.Flags = fi.Flags
+	record.Type = string(fi.Type)
+	record.TypeName = fi.TypeName
+	record.Client = fi.ClientID.String()
+	record.TTL = fi.TTL.Format(time.RFC3339)
+	record.Expired = fi.Expired(now)
+	record.Problem = fi.Problem
+	record.Headers = fi.Headers
+	record.Size = len(fi.Payload)
+	record.Payload, record.Encoding, record.Truncated = d.c.previewPayload(fi.Payload)
+}
+
+func (d *dumper) print(record dumpRecord) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.c.json {
+		return json.NewEncoder(d.c.stdout).Encode(record)
+	}
+	_, err := fmt.Fprintln(d.c.stdout, record.text())
+	return err
+}
+
+// text is the human-readable line of the record
+func (r dumpRecord) text() string {
+	var b strings.Builder
+	if r.Time != "" {
+		b.WriteString(r.Time + " ")
+	}
+	if r.Direction != "" {
+		b.WriteString(r.Direction + " ")
+	}
+	fmt.Fprintf(&b, "%s@%d len=%d", r.Source, r.Offset, r.Length)
+
+	if r.Malformed != "" {
+		fmt.Fprintf(&b, " MALFORMED %s", r.Malformed)
+		return b.String()
+	}
+
+	flags := "-"
+	if len(r.Flags) > 0 {
+		flags = strings.Join(r.Flags, ",")
+	}
+	fmt.Fprintf(&b, " flag=%s version=%d flags=%s type=%s(%s) client=%s ttl=%s", r.Flag, r.Version, flags, r.Type, r.TypeName, r.Client, r.TTL)
+	if r.Expired {
+		b.WriteString(" EXPIRED")
+	}
+	if r.Problem != "" {
+		fmt.Fprintf(&b, " PROBLEM=%q", r.Problem)
+	}
+	if len(r.Headers) > 0 {
+		keys := make([]string, 0, len(r.Headers))
+		for k := range r.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for i, k := range keys {
+			keys[i] = k + "=" + strconv.Quote(r.Headers[k])
+		}
+		fmt.Fprintf(&b, " headers={%s}", strings.Join(keys, " "))
+	}
+
+	payload := strconv.Quote(r.Payload)
+	if r.Encoding == "hex" {
+		payload = "hex:" + r.Payload
+	}
+	if r.Truncated {
+		payload += "..."
+	}
+	fmt.Fprintf(&b, " payload(%d)=%s", r.Size, payload)
+
+	return b.String()
+}
+
+// previewPayload return the first -preview bytes of payload, as utf-8 when it is printable text and -hex is not set
+func (c *config) previewPayload(payload []byte) (preview, encoding string, truncated bool) {
+	text := !c.hex && utf8.Valid(payload) && strings.IndexFunc(string(payload), func(r rune) bool {
+		return !unicode.IsPrint(r) && !unicode.IsSpace(r)
+	}) < 0
+
+	if c.preview > 0 && len(payload) > c.preview {
+		payload, truncated = payload[:c.preview], true
+		for text && !utf8.Valid(payload) {
+			payload = payload[:len(payload)-1]
+		}
+	}
+	if text {
+		return string(payload), "utf-8", truncated
+	}
+
+	return hex.EncodeToString(payload), "hex", truncated
+}
+
+// proxy relay the pair <proxy>.r and <proxy>.w to the named pipes of the server and print what goes through
+//
+// The clients are pointed at the pair of the proxy, the server keeps its own.
+func (d *dumper) proxy(ctx context.Context) error {
+	toServer, err := os.OpenFile(filepath.Join(d.c.chroot, d.c.read), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	fromServer, err := os.OpenFile(filepath.Join(d.c.chroot, d.c.write), os.O_RDWR, 0)
+	if err != nil {
+		toServer.Close()
+		return err
+	}
+	fromClients, err := openProxyFifo(filepath.Join(d.c.chroot, d.c.proxy+".r"))
+	if err != nil {
+		toServer.Close()
+		fromServer.Close()
+		return err
+	}
+	toClients, err := openProxyFifo(filepath.Join(d.c.chroot, d.c.proxy+".w"))
+	if err != nil {
+		toServer.Close()
+		fromServer.Close()
+		fromClients.Close()
+		return err
+	}
+
+	files := []*os.File{toServer, fromServer, fromClients, toClients}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+		os.Remove(fromClients.Name())
+		os.Remove(toClients.Name())
+	}()
+
+	errs := make(chan error, 2)
+	go func() { errs <- d.relay(fromClients, toServer, "c>s") }()
+	go func() { errs <- d.relay(fromServer, toClients, "s>c") }()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err = <-errs:
+		return err
+	}
+}
+
+// relay copy src to dst and print the frames on the way
+func (d *dumper) relay(src io.Reader, dst io.Writer, direction string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := io.Copy(io.MultiWriter(dst, pw), src)
+		pw.CloseWithError(err)
+	}()
+
+	return d.stream(pr, "", direction)
+}
+
+// openProxyFifo create the named pipe at path if it does not exist and open it for read and write
+func openProxyFifo(path string) (*os.File, error) {
+	if err := syscall.Mkfifo(path, 0600); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if s, err := f.Stat(); err != nil || s.Mode()&os.ModeNamedPipe == 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a named pipe", path)
+	}
+
+	return f, nil
+}
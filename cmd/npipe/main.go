@@ -0,0 +1,195 @@
+// Command npipe send, receive and serve the messages of named-pipe-ipc from the shell
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+const usage = `usage: npipe <command> [flags] [payload]
+
+commands:
+  send     send a payload, from the arguments, -file or stdin
+  call     send a payload and print the reply of the server
+  listen   act as the server and print the payload of every frame
+  serve    act as the server and reply with the output of --exec
+  dump     print the frames of captured streams, or of the traffic relayed by -proxy
+  replay   send the requests of a capture again, or -serve its replies
+  bench    measure the throughput and the latency of clients against a server
+
+run "npipe <command> -h" for the flags of a command
+`
+
+var commands = map[string]func(ctx context.Context, c *config, args []string) error{
+	"send":   send,
+	"call":   call,
+	"listen": listen,
+	"serve":  serve,
+	"dump":   dump,
+	"replay": replay,
+	"bench":  bench,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "npipe: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	c := &config{stdin: stdin, stdout: stdout, stderr: stderr}
+	fs := c.flags(args[0])
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	defer c.closeRecording()
+	if err := cmd(ctx, c, fs.Args()); err != nil {
+		fmt.Fprintf(stderr, "npipe %s: %v\n", args[0], err)
+		return 1
+	}
+
+	return 0
+}
+
+// config is what the flags of a command set
+type config struct {
+	chroot  string
+	read    string
+	write   string
+	delim   string
+	ttl     time.Duration
+	timeout time.Duration
+	file    string
+	exec    string
+	workers int
+	json    bool
+	hex     bool
+	preview int
+	proxy   string
+	record  string
+	speed   float64
+	serve   bool
+
+	clients  int
+	requests int
+	sizes    string
+	wait     time.Duration
+	procs    bool
+	external bool
+	worker   bool
+
+	recording *os.File
+
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (c *config) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("npipe "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+
+	fs.StringVar(&c.chroot, "chroot", "./", "directory of the named pipes")
+	fs.StringVar(&c.read, "read", "golang.pipe.1.r", "named pipe the server reads")
+	fs.StringVar(&c.write, "write", "golang.pipe.1.w", "named pipe the server writes")
+	fs.StringVar(&c.delim, "delim", `\n`, "delimiter written after every frame, Go escapes are allowed")
+	fs.DurationVar(&c.ttl, "ttl", 10*time.Second, "time to live of the frames")
+	fs.DurationVar(&c.timeout, "timeout", 0, "give up after this long, 0 for never")
+
+	if name != "dump" && name != "bench" {
+		fs.StringVar(&c.record, "record", "", "write the frames sent and received to this capture file")
+	}
+
+	switch name {
+	case "send", "call":
+		fs.StringVar(&c.file, "file", "", "read the payload from this file, - for stdin")
+	case "serve":
+		fs.StringVar(&c.exec, "exec", "", "shell command which gets the payload on stdin and writes the reply on stdout")
+		fs.IntVar(&c.workers, "workers", 1, "how many frames are served at the same time")
+	case "dump":
+		fs.BoolVar(&c.json, "json", false, "print a JSON object per line")
+		fs.BoolVar(&c.hex, "hex", false, "print the payloads in hex even when they are utf-8")
+		fs.IntVar(&c.preview, "preview", 64, "bytes of payload printed, 0 for all")
+		fs.StringVar(&c.proxy, "proxy", "", "relay the pair <proxy>.r and <proxy>.w to -read and -write and print the frames")
+	case "replay":
+		fs.Float64Var(&c.speed, "speed", 1, "pace of the capture, 2 is twice as fast, 0 does not wait")
+		fs.BoolVar(&c.serve, "serve", false, "act as the server and answer with the replies of the capture")
+	case "bench":
+		fs.IntVar(&c.clients, "clients", 10, "how many clients Call the server at the same time")
+		fs.IntVar(&c.requests, "requests", 1000, "how many Calls each client makes")
+		fs.StringVar(&c.sizes, "sizes", "16,1024,65536", "payload sizes in bytes, a round each")
+		fs.DurationVar(&c.wait, "wait", 5*time.Second, "a request without reply after this long is lost")
+		fs.BoolVar(&c.procs, "procs", false, "run every client in its own process")
+		fs.BoolVar(&c.external, "external", false, "Call the echo server of -chroot instead of starting one")
+		fs.IntVar(&c.workers, "workers", 1, "how many frames the server handles at the same time")
+		fs.BoolVar(&c.json, "json", false, "print a JSON object per payload size")
+		fs.BoolVar(&c.worker, "worker", false, "run one client and print its result, for -procs")
+	}
+
+	return fs
+}
+
+// context open the named pipes with the options of the flags
+func (c *config) context(ctx context.Context, role named_pipe_ipc.RoleType, opts ...named_pipe_ipc.Option) (*named_pipe_ipc.Context, error) {
+	delim, err := c.delimiter()
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append([]named_pipe_ipc.Option{
+		named_pipe_ipc.WithNamedPipeForRead(c.read),
+		named_pipe_ipc.WithNamedPipeForWrite(c.write),
+		named_pipe_ipc.WithDelim(delim),
+		named_pipe_ipc.WithTTL(c.ttl),
+	}, opts...)
+	if c.record != "" {
+		if c.recording, err = os.Create(c.record); err != nil {
+			return nil, err
+		}
+		opts = append(opts, named_pipe_ipc.WithRecorder(c.recording))
+	}
+
+	return named_pipe_ipc.NewContext(ctx, c.chroot, role, opts...)
+}
+
+// delimiter unquote -delim, which must be one byte
+func (c *config) delimiter() (byte, error) {
+	delim, err := strconv.Unquote(`"` + c.delim + `"`)
+	if err != nil || len(delim) != 1 {
+		return 0, fmt.Errorf("delimiter %q is not one byte", c.delim)
+	}
+
+	return delim[0], nil
+}
+
+func (c *config) closeRecording() {
+	if c.recording != nil {
+		c.recording.Close()
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func replay(ctx context.Context, c *config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("one capture file is required")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	capture, err := named_pipe_ipc.ReadCapture(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if c.serve {
+		return replayServe(ctx, c, capture)
+	}
+
+	nctx, err := c.context(ctx, named_pipe_ipc.C)
+	if err != nil {
+		return err
+	}
+	results, err := capture.Replay(ctx, nctx, c.speed)
+	if err != nil {
+		return err
+	}
+
+	differ := 0
+	for i, r := range results {
+		line := fmt.Sprintf("%d %s", i+1, strconv.Quote(r.Request.String()))
+		switch {
+		case r.Recorded == nil:
+			line += " sent"
+		case bytes.Equal(r.Reply, r.Recorded):
+			line += " ok " + strconv.Quote(r.Reply.String())
+		default:
+			differ++
+			line += fmt.Sprintf(" DIFF %s recorded %s", strconv.Quote(r.Reply.String()), strconv.Quote(r.Recorded.String()))
+		}
+		if _, err = fmt.Fprintln(c.stdout, line); err != nil {
+			return err
+		}
+	}
+	if differ > 0 {
+		return fmt.Errorf("%d of %d replies differ from the capture", differ, len(results))
+	}
+
+	return nil
+}
+
+// replayServe answer the clients with the replies of the capture
+func replayServe(ctx context.Context, c *config, capture *named_pipe_ipc.Capture) error {
+	handler, err := capture.Handler(c.speed)
+	if err != nil {
+		return err
+	}
+	nctx, err := c.context(ctx, named_pipe_ipc.S)
+	if err != nil {
+		return err
+	}
+	defer nctx.Close()
+
+	err = nctx.Serve(handler)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,159 @@
+package named_pipe_ipc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"log/slog"
+)
+
+const (
+	contentEncodingKey = "content-encoding"
+	compressFeature    = "compress/"
+)
+
+// Compressor compress the payload of the frames
+type Compressor interface {
+	// Name is the content-encoding header of the frames it compresses
+	Name() string
+	Compress(w io.Writer) (io.WriteCloser, error)
+	Decompress(r io.Reader) (io.Reader, error)
+}
+
+// Gzip is the Compressor of compress/gzip
+type Gzip struct {
+	Level int
+}
+
+func (c Gzip) Name() string {
+	return "gzip"
+}
+
+func (c Gzip) Compress(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, c.Level)
+}
+
+func (c Gzip) Decompress(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+// Flate is the Compressor of compress/flate
+type Flate struct {
+	Level int
+}
+
+func (c Flate) Name() string {
+	return "deflate"
+}
+
+func (c Flate) Compress(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, c.Level)
+}
+
+func (c Flate) Decompress(r io.Reader) (io.Reader, error) {
+	return flate.NewReader(r), nil
+}
+
+// builtinCompressors are the Compressors every Context decompresses
+var builtinCompressors = []Compressor{Gzip{gzip.DefaultCompression}, Flate{flate.DefaultCompression}}
+
+// WithCompression compress the payloads of at least minSize bytes with compressor
+//
+// It is offered as the feature "compress/<name>" in the Handshake, frames are only compressed for
+// a peer which agreed on it. The frames received are decompressed whatever the option.
+func WithCompression(compressor Compressor, minSize int) Option {
+	return OptionsFunc(func(o *options) {
+		o.compressor = compressor
+		o.compressMinSize = minSize
+		o.features = append(o.features[:len(o.features):len(o.features)], compressFeature+compressor.Name())
+	})
+}
+
+// compress replace the payload of frame by its compressed form when the peer agreed on it and it is worth it
+func (nctx *Context) compress(frame Message) (Message, error) {
+	c := nctx.options.compressor
+	payload := frame.segmentPayload()
+	if c == nil || len(payload) < nctx.options.compressMinSize || frame.segmentFrameFlags()&(frameFlagCompressed|frameFlagEncrypted) != 0 || !nctx.peerCapabilities(frame).Has(compressFeature+c.Name()) {
+		return frame, nil
+	}
+
+	var buf bytes.Buffer
+	w, err := c.Compress(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(payload) {
+		return frame, nil
+	}
+
+	md, err := frame.segmentMetadata()
+	if err != nil {
+		return nil, err
+	}
+	md[contentEncodingKey] = c.Name()
+	m, err := frame.rebuild(md, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	m[m.segmentFrameFlagsOffset()] |= frameFlagCompressed
+
+	return m, nil
+}
+
+// decompress restore the payload of a compressed frame, up to limit bytes
+func (nctx *Context) decompress(frame Message, limit int64) (Message, error) {
+	m, err := decompressFrame(frame, append([]Compressor{nctx.options.compressor}, builtinCompressors...), limit)
+	if _, ok := err.(UnknownCompression); ok {
+		nctx.logger.Warn("frame dropped, unknown content-encoding", append(frameAttrs(frame), slog.String("encoding", frame.Header(contentEncodingKey)))...)
+	}
+
+	return m, err
+}
+
+// decompressFrame restore the payload of a compressed frame with the first of compressors named by its content-encoding
+func decompressFrame(frame Message, compressors []Compressor, limit int64) (Message, error) {
+	md, err := frame.segmentMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	name := md[contentEncodingKey]
+	var c Compressor
+	for _, bc := range compressors {
+		if bc != nil && bc.Name() == name {
+			c = bc
+			break
+		}
+	}
+	if c == nil {
+		return nil, UnknownCompression{}
+	}
+
+	r, err := c.Decompress(bytes.NewReader(frame.segmentPayload()))
+	if err != nil {
+		return nil, err
+	}
+	payload, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(payload)) > limit {
+		return nil, TooLarge{}
+	}
+
+	delete(md, contentEncodingKey)
+	m, err := frame.rebuild(md, payload)
+	if err != nil {
+		return nil, err
+	}
+	m[m.segmentFrameFlagsOffset()] &^= frameFlagCompressed
+
+	return m, nil
+}
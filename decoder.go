@@ -0,0 +1,173 @@
+package named_pipe_ipc
+
+import (
+	"bytes"
+	"io"
+)
+
+const (
+	defaultMaxFrameLength = 64 << 20
+	decoderChunkLen       = 4096
+)
+
+// WithMaxFrameLength set the greatest byteLength a reader trusts, a frame announcing more is taken for garbage
+func WithMaxFrameLength(n int64) Option {
+	return OptionsFunc(func(o *options) {
+		o.maxFrameLength = n
+	})
+}
+
+// decoder cut the frames out of a byte stream
+//
+// It trusts a byteLength only after it found the flag behind it, and only when it is between the smallest
+// frame and maxLength and the frame ends with the delim. Otherwise it drops a byte and scans forward
+// for the next flag, so that a stray write costs the frames it hits and not the stream.
+type decoder struct {
+	r         io.Reader
+	buf       []byte
+	delim     byte
+	maxLength int64
+	// maxMessageSize is the greatest byteLength of a frame we accept, 0 for no limit
+	maxMessageSize int64
+	// exact is set when other readers share the stream, the decoder then reads no byte past the frame it needs
+	exact bool
+
+	// resync is called with the number of bytes dropped and why
+	resync func(skipped int, reason string)
+}
+
+func newDecoder(r io.Reader, delim byte, maxLength int64, resync func(int, string)) *decoder {
+	return &decoder{
+		r:         r,
+		delim:     delim,
+		maxLength: maxLength,
+		resync:    resync,
+	}
+}
+
+func minFrameLength() int64 {
+	var M Message
+	// header, empty metadata and delim
+	return int64(M.segmentHeaderLen() + 3 + 1)
+}
+
+// next return the next frame, without the delim
+func (d *decoder) next() (Message, error) {
+	var M Message
+	flagEnd := M.segmentPackageLengthLen() + M.segmentFlagLen()
+
+	for {
+		// the byte behind the flag tells a frame of the layout before the version byte
+		if err := d.fill(flagEnd + 1); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(d.buf[M.segmentPackageLengthLen():flagEnd], []byte(protoFlag)) {
+			d.skip("no flag")
+			continue
+		}
+
+		length := Message(d.buf).segmentPackageLength()
+		baseline := Message(d.buf).isBaseline()
+		minLength := minFrameLength()
+		if baseline {
+			minLength = int64(baselineHeaderLen + 1)
+		}
+		if length < minLength || length > d.maxLength {
+			d.skip("bad package length")
+			continue
+		}
+
+		if d.maxMessageSize > 0 && length > d.maxMessageSize {
+			// the length looks right, the frame is skipped without being buffered
+			if err := d.fill(M.segmentHeaderLen()); err != nil {
+				return nil, err
+			}
+			header := append(Message(nil), d.buf[:M.segmentHeaderLen()]...)
+			if err := d.discard(length); err != nil {
+				return nil, err
+			}
+			return nil, frameTooLarge{header}
+		}
+
+		if err := d.fill(int(length)); err != nil {
+			return nil, err
+		}
+		frame := Message(d.buf[: length-1 : length-1])
+		if d.buf[length-1] != d.delim || !baseline && !frame.isWellFormed() {
+			d.skip("malformed frame")
+			continue
+		}
+
+		d.buf = d.buf[length:]
+		return frame, nil
+	}
+}
+
+// fill read until n bytes are buffered
+func (d *decoder) fill(n int) error {
+	for len(d.buf) < n {
+		chunk := n - len(d.buf)
+		if chunk < decoderChunkLen && !d.exact {
+			chunk = decoderChunkLen
+		}
+		if cap(d.buf)-len(d.buf) < chunk {
+			buf := make([]byte, len(d.buf), len(d.buf)+chunk)
+			copy(buf, d.buf)
+			d.buf = buf
+		}
+
+		end := cap(d.buf)
+		if d.exact {
+			end = n
+		}
+		nn, err := d.r.Read(d.buf[len(d.buf):end])
+		d.buf = d.buf[:len(d.buf)+nn]
+		if err != nil && len(d.buf) < n {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// discard drop the next n bytes of the stream
+func (d *decoder) discard(n int64) error {
+	if int64(len(d.buf)) >= n {
+		d.buf = d.buf[n:]
+		return nil
+	}
+
+	n -= int64(len(d.buf))
+	d.buf = d.buf[:0]
+	_, err := io.CopyN(io.Discard, d.r, n)
+
+	return err
+}
+
+// frameTooLarge is returned by the decoder for a frame over maxMessageSize, with the header of the frame
+type frameTooLarge struct {
+	header Message
+}
+
+func (e frameTooLarge) Error() string {
+	return TooLargeMessage
+}
+
+// skip drop the bytes before the next place a frame may begin, the flag after the first byte
+func (d *decoder) skip(reason string) {
+	var M Message
+	skipped := len(d.buf)
+	if i := bytes.Index(d.buf[M.segmentPackageLengthLen()+1:], []byte(protoFlag)); i >= 0 {
+		skipped = i + 1
+	} else if tail := M.segmentPackageLengthLen() + M.segmentFlagLen() - 1; skipped > tail {
+		// keep what may be the beginning of the next flag
+		skipped -= tail
+	} else {
+		skipped = 1
+	}
+
+	d.buf = d.buf[skipped:]
+	if d.resync != nil {
+		d.resync(skipped, reason)
+	}
+}
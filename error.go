@@ -9,6 +9,34 @@ const (
 	MessageNotLegalMessage             = "Message is not legal"
 	NoPipeExistMessage                 = "No pipe exist"
 	PipeClosedMessage                  = "pipe closed"
+	NotServerRoleMessage               = "It is not a server role"
+	NotClientRoleMessage               = "It is not a client role"
+	HandlerPanicMessage                = "Handler panic"
+	MetadataTooLargeMessage            = "Metadata too large"
+	UnsupportedMetadataVersionMessage  = "Unsupported metadata version"
+	IncompatibleVersionMessage         = "Incompatible protocol version"
+	ChecksumMismatchMessage            = "Checksum mismatch"
+	TooLargeMessage                    = "Message too large"
+	UnknownCompressionMessage          = "Unknown compression"
+	EmptySharedSecretMessage           = "Empty shared secret"
+	BadMACMessage                      = "Bad message authentication code"
+	ReplayedMessage                    = "Replayed or expired message"
+	CannotDecryptMessage               = "Cannot decrypt message"
+	UnexpectedOwnerMessage             = "Named pipe of unexpected owner"
+	PermissiveModeMessage              = "Named pipe of too permissive mode"
+	UnauthenticatedMessage             = "Client not authenticated"
+	PermissionDeniedMessage            = "Permission denied"
+	AlreadyExistButNotSocketMessage    = "Already exist but which not unix socket"
+	UnknownClientMessage               = "No connection of the client"
+	NotSharedMemoryMessage             = "Already exist but which not shared memory"
+	SharedMemoryBusyMessage            = "Shared memory already used by a client"
+	UnsupportedTransportMessage        = "Transport not supported on this platform"
+	MalformedFrameMessage              = "Malformed frame"
+	NotCaptureMessage                  = "It is not a capture"
+	UnsupportedCaptureVersionMessage   = "Unsupported capture version"
+	CaptureExhaustedMessage            = "No reply left in the capture"
+	InsecureAuthenticatorMessage       = "Authenticator without encryption"
+	UnverifiedUidMessage               = "Uid rules need a transport which tells the uid of its peers"
 )
 
 type AlreadyExistButNotNamedPipe struct {
@@ -61,3 +89,229 @@ type HybridError struct {
 func (e HybridError) Error() string {
 	return fmt.Sprintf("EA: %v, EB: %v", e.EA, e.EB)
 }
+
+type NotServerRole struct {
+}
+
+func (e NotServerRole) Error() string {
+	return NotServerRoleMessage
+}
+
+type NotClientRole struct {
+}
+
+func (e NotClientRole) Error() string {
+	return NotClientRoleMessage
+}
+
+type HandlerPanic struct {
+	Value interface{}
+}
+
+func (e HandlerPanic) Error() string {
+	return fmt.Sprintf("%s: %v", HandlerPanicMessage, e.Value)
+}
+
+type MetadataTooLarge struct {
+}
+
+func (e MetadataTooLarge) Error() string {
+	return MetadataTooLargeMessage
+}
+
+type UnsupportedMetadataVersion struct {
+}
+
+func (e UnsupportedMetadataVersion) Error() string {
+	return UnsupportedMetadataVersionMessage
+}
+
+type IncompatibleVersion struct {
+}
+
+func (e IncompatibleVersion) Error() string {
+	return IncompatibleVersionMessage
+}
+
+type ChecksumMismatch struct {
+}
+
+func (e ChecksumMismatch) Error() string {
+	return ChecksumMismatchMessage
+}
+
+type TooLarge struct {
+}
+
+func (e TooLarge) Error() string {
+	return TooLargeMessage
+}
+
+type UnknownCompression struct {
+}
+
+func (e UnknownCompression) Error() string {
+	return UnknownCompressionMessage
+}
+
+type EmptySharedSecret struct {
+}
+
+func (e EmptySharedSecret) Error() string {
+	return EmptySharedSecretMessage
+}
+
+type BadMAC struct {
+}
+
+func (e BadMAC) Error() string {
+	return BadMACMessage
+}
+
+type Replayed struct {
+}
+
+func (e Replayed) Error() string {
+	return ReplayedMessage
+}
+
+type CannotDecrypt struct {
+}
+
+func (e CannotDecrypt) Error() string {
+	return CannotDecryptMessage
+}
+
+type UnexpectedOwner struct {
+}
+
+func (e UnexpectedOwner) Error() string {
+	return UnexpectedOwnerMessage
+}
+
+type PermissiveMode struct {
+}
+
+func (e PermissiveMode) Error() string {
+	return PermissiveModeMessage
+}
+
+type Unauthenticated struct {
+}
+
+func (e Unauthenticated) Error() string {
+	return UnauthenticatedMessage
+}
+
+type InsecureAuthenticator struct {
+}
+
+func (e InsecureAuthenticator) Error() string {
+	return InsecureAuthenticatorMessage
+}
+
+type UnverifiedUid struct {
+}
+
+func (e UnverifiedUid) Error() string {
+	return UnverifiedUidMessage
+}
+
+type PermissionDenied struct {
+}
+
+func (e PermissionDenied) Error() string {
+	return PermissionDeniedMessage
+}
+
+type AlreadyExistButNotSocket struct {
+}
+
+func (e AlreadyExistButNotSocket) Error() string {
+	return AlreadyExistButNotSocketMessage
+}
+
+type UnknownClient struct {
+}
+
+func (e UnknownClient) Error() string {
+	return UnknownClientMessage
+}
+
+type NotSharedMemory struct {
+}
+
+func (e NotSharedMemory) Error() string {
+	return NotSharedMemoryMessage
+}
+
+type SharedMemoryBusy struct {
+}
+
+func (e SharedMemoryBusy) Error() string {
+	return SharedMemoryBusyMessage
+}
+
+type UnsupportedTransport struct {
+}
+
+func (e UnsupportedTransport) Error() string {
+	return UnsupportedTransportMessage
+}
+
+type NotCapture struct {
+}
+
+func (e NotCapture) Error() string {
+	return NotCaptureMessage
+}
+
+type UnsupportedCaptureVersion struct {
+}
+
+func (e UnsupportedCaptureVersion) Error() string {
+	return UnsupportedCaptureVersionMessage
+}
+
+type CaptureExhausted struct {
+}
+
+func (e CaptureExhausted) Error() string {
+	return CaptureExhaustedMessage
+}
+
+// MalformedFrame is returned by a Decoder for the bytes of a stream which are not a frame
+type MalformedFrame struct {
+	Offset int64
+	Length int
+	Reason string
+}
+
+func (e MalformedFrame) Error() string {
+	return fmt.Sprintf("%s: %s", MalformedFrameMessage, e.Reason)
+}
+
+// RemoteError is the error carried by an error frame from the other side of the pipe
+type RemoteError struct {
+	Message string
+}
+
+func (e RemoteError) Error() string {
+	return e.Message
+}
+
+// remoteError turn the payload of an error frame into the error of this package it was, if any
+func remoteError(payload Message) error {
+	switch payload.String() {
+	case IncompatibleVersionMessage:
+		return IncompatibleVersion{}
+	case TooLargeMessage:
+		return TooLarge{}
+	case UnauthenticatedMessage:
+		return Unauthenticated{}
+	case PermissionDeniedMessage:
+		return PermissionDenied{}
+	default:
+		return RemoteError{payload.String()}
+	}
+}
@@ -1,7 +1,9 @@
 module example
 
-go 1.15
+go 1.21
 
 require github.com/whiteCcinn/named-pipe-ipc v0.0.1
 
+require github.com/satori/go.uuid v1.2.0 // indirect
+
 replace github.com/whiteCcinn/named-pipe-ipc => ../
@@ -0,0 +1,179 @@
+package named_pipe_ipc
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+)
+
+// fifoTransport is the default Transport, a FIFO the server reads and a FIFO every client reads
+//
+// A client reads the frames of every client, it sends back those of the others to the server.
+// The clients share both FIFOs, where the platform allows it they read and write them a frame at a time.
+type fifoTransport struct {
+	nctx    *Context
+	rPipe   *os.File
+	wPipe   *os.File
+	bw      *bufio.Writer
+	decoder *decoder
+	// peer is the owner of the FIFO we read, the user which can write to it
+	peer Peer
+	// shared is set for a client, the FIFOs are locked around the frames it reads and writes
+	shared bool
+}
+
+func (t *fifoTransport) Open(nctx *Context) error {
+	t.nctx = nctx
+	if nctx.role == S {
+		if err := createFifo(nctx); err != nil {
+			return err
+		}
+	} else if err := verifyServerFifo(nctx); err != nil {
+		return err
+	}
+	t.peer = fifoPeer(nctx.namedPipeForReadFullPath())
+
+	return openPipeFile(nctx, t)
+}
+
+func (t *fifoTransport) Read() (Message, Peer, error) {
+	frame, err := t.readFrame()
+	return frame, t.peer, err
+}
+
+func (t *fifoTransport) Write(frame []byte) error {
+	return t.writeFrame(frame)
+}
+
+func (t *fifoTransport) write(frame []byte) error {
+	if _, err := t.bw.Write(frame); err != nil {
+		return err
+	}
+
+	return t.bw.Flush()
+}
+
+func (t *fifoTransport) Close() error {
+	nctx := t.nctx
+	nctx.logger.Debug("fifo closed", slog.String("read", nctx.namedPipeForReadFullPath()), slog.String("write", nctx.namedPipeForWriteFullPath()))
+
+	if t.rPipe != nil {
+		if err := t.rPipe.Close(); err != nil {
+			if pe, ok := err.(*os.PathError); ok {
+				if pe.Err != os.ErrClosed {
+					return err
+				}
+			} else {
+				return err
+			}
+		}
+	}
+
+	if t.wPipe != nil {
+		if err := t.wPipe.Close(); err != nil {
+			if pe, ok := err.(*os.PathError); ok {
+				if pe.Err != os.ErrClosed {
+					return err
+				}
+			} else {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// remove delete the FIFOs when the server closes, they are its own and its other clients still use them
+func (t *fifoTransport) remove() error {
+	nctx := t.nctx
+	if nctx.role != S {
+		return nil
+	}
+	if IsFile(nctx.namedPipeForWriteFullPath()) {
+		err := os.Remove(nctx.namedPipeForWriteFullPath())
+		if err != nil {
+			return err
+		}
+		nctx.logger.Debug("fifo removed", slog.String("path", nctx.namedPipeForWriteFullPath()))
+	}
+
+	if IsFile(nctx.namedPipeForReadFullPath()) {
+		err := os.Remove(nctx.namedPipeForReadFullPath())
+		if err != nil {
+			return err
+		}
+		nctx.logger.Debug("fifo removed", slog.String("path", nctx.namedPipeForReadFullPath()))
+	}
+
+	return nil
+}
+
+func createFifo(nctx *Context) (err error) {
+	uid, gid := nctx.options.ownerOf()
+	for _, path := range []string{nctx.namedPipeForReadFullPath(), nctx.namedPipeForWriteFullPath()} {
+		if ex, err := Exists(path); err != nil {
+			return err
+		} else if !ex {
+			if err = nctx.makeFifo(path); err != nil {
+				return err
+			}
+		} else if err = nctx.verifyFifo(path, uid, gid); err != nil {
+			// a FIFO we did not create is only reused when it is as private as one we would create
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyServerFifo check the owner of the FIFOs of the server before a client opens them
+func verifyServerFifo(nctx *Context) error {
+	if nctx.options.expectedOwner == anyOwner {
+		return nil
+	}
+
+	for _, path := range []string{nctx.namedPipeForReadFullPath(), nctx.namedPipeForWriteFullPath()} {
+		if err := nctx.verifyFifo(path, nctx.options.expectedOwner, anyOwner); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// openPipeFile
+//
+// Why use os.RDWR? not os.RD_ONLY or os.WD_ONLY ?
+//
+// When the FIFO is turned on, the non-blocking flag (O_NONBLOCK) has the following effects:
+// i. O_NONBLOCK is not specified (i.e. open has no bit or O_NONBLOCK).
+// 	2. When a FIFO is opened in read-only mode, the FIFO is blocked until a process opens the FIFO for writing
+// 	3. When the FIFO is opened in write-only mode, it is blocked until a process opens the FIFO for reads.
+// 	4. When the FIFO is opened in read-only, write-only mode, it blocks. When the read function is called to read data from the FIFO, the read function also blocks.
+// 	4, Call the write function to write data to the FIFO, and write will block when the buffer is full.
+// 	5, communication process if the writing process first quit, then call the read function to read data from the FIFO does not block; If the writing process starts again, the read function is called to read data from the FIFO.
+// 	6. During the communication process, when the reader process exits and the writer process writes data to the named pipe, the writer process will also exit (receiving SIGPIPE signal).
+// If no process has opened a FIFO for write, read - only open succeeds, and open is not blocked.
+// ii. Specify O_NONBLOCK(that is, open bit or O_NONBLOCK)
+// 	1. If no process has opened a FIFO for read, writing only open will return -1.
+// 	2. Named pipes do not block when reading data.
+//  3. During the communication process, when the reader process exits and the writer process writes data to the named pipe, the writer process will also exit (receiving SIGPIPE signal).
+func openPipeFile(nctx *Context, t *fifoTransport) (err error) {
+	t.rPipe, err = os.OpenFile(nctx.namedPipeForReadFullPath(), os.O_RDWR, os.ModeNamedPipe)
+	if err != nil {
+		return err
+	}
+	t.wPipe, err = os.OpenFile(nctx.namedPipeForWriteFullPath(), os.O_RDWR, os.ModeNamedPipe)
+	if err != nil {
+		return err
+	}
+
+	t.decoder = nctx.newDecoder(t.rPipe)
+	t.bw = bufio.NewWriter(t.wPipe)
+	t.share()
+
+	nctx.logger.Debug("fifo opened", slog.String("read", nctx.namedPipeForReadFullPath()), slog.String("write", nctx.namedPipeForWriteFullPath()), slog.String("role", nctx.role.name()))
+
+	return nil
+}
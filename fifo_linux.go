@@ -0,0 +1,121 @@
+package named_pipe_ipc
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// pipeBuf is PIPE_BUF, a write to a FIFO up to this size is not interleaved with the writes of others
+const pipeBuf = 4096
+
+// share lock the FIFOs of a client around the frames it reads and writes
+//
+// The clients read the same FIFO: without the lock a read may take a frame and the beginning of the next one,
+// which another client wanted, or the decoder may keep the frames of other clients while its client waits for nothing.
+// Under the lock a client reads one frame and not a byte more, the others wait for it.
+func (t *fifoTransport) share() {
+	t.shared = t.nctx.role == C
+	t.decoder.exact = t.shared
+}
+
+// readFrame wait for the FIFO to hold bytes, then read a frame under the lock
+func (t *fifoTransport) readFrame() (Message, error) {
+	if !t.shared {
+		return t.decoder.next()
+	}
+
+	raw, err := t.rPipe.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if len(t.decoder.buf) == 0 {
+			// wait without the lock, its holder only keeps it while it reads a frame which is there
+			var ierr error
+			if err = raw.Read(func(fd uintptr) bool {
+				var n int
+				n, ierr = fionread(fd)
+				return ierr != nil || n > 0
+			}); err != nil {
+				return nil, &os.PathError{Op: "read", Path: t.rPipe.Name(), Err: os.ErrClosed}
+			}
+			if ierr != nil {
+				return nil, ierr
+			}
+		}
+
+		if err = flock(raw, syscall.LOCK_EX); err != nil {
+			return nil, err
+		}
+		var n int
+		if len(t.decoder.buf) == 0 {
+			// another client may have read the bytes we waited for
+			_ = raw.Control(func(fd uintptr) {
+				n, err = fionread(fd)
+			})
+			if err != nil || n == 0 {
+				_ = flock(raw, syscall.LOCK_UN)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		frame, err := t.decoder.next()
+		_ = flock(raw, syscall.LOCK_UN)
+		return frame, err
+	}
+}
+
+// writeFrame write a frame under the lock, shared up to PIPE_BUF and exclusive over it
+//
+// A write up to PIPE_BUF is not interleaved with the other writes, several of them may go at once.
+// A larger one is made of several writes, no other frame may come between them.
+func (t *fifoTransport) writeFrame(frame []byte) error {
+	if !t.shared {
+		return t.write(frame)
+	}
+
+	raw, err := t.wPipe.SyscallConn()
+	if err != nil {
+		return err
+	}
+	how := syscall.LOCK_SH
+	if len(frame) > pipeBuf {
+		how = syscall.LOCK_EX
+	}
+	if err = flock(raw, how); err != nil {
+		return err
+	}
+	defer flock(raw, syscall.LOCK_UN)
+
+	return t.write(frame)
+}
+
+// flock apply flock(2) how to the file of raw, os.ErrClosed once it is closed
+func flock(raw syscall.RawConn, how int) error {
+	var err error
+	if cerr := raw.Control(func(fd uintptr) {
+		for {
+			if err = syscall.Flock(int(fd), how); err != syscall.EINTR {
+				return
+			}
+		}
+	}); cerr != nil {
+		return os.ErrClosed
+	}
+
+	return err
+}
+
+// fionread return the number of bytes waiting in the FIFO fd
+func fionread(fd uintptr) (int, error) {
+	var n int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCINQ, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		return 0, errno
+	}
+
+	return int(n), nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package named_pipe_ipc
+
+// share leave the FIFOs of a client unlocked, they are only locked on linux
+//
+// The clients may then take pieces of the frames of each other, and the frames over PIPE_BUF they write may interleave.
+func (t *fifoTransport) share() {}
+
+func (t *fifoTransport) readFrame() (Message, error) {
+	return t.decoder.next()
+}
+
+func (t *fifoTransport) writeFrame(frame []byte) error {
+	return t.write(frame)
+}
@@ -1,5 +1,5 @@
 module github.com/whiteCcinn/named-pipe-ipc
 
-go 1.15
+go 1.21
 
 require github.com/satori/go.uuid v1.2.0
@@ -0,0 +1,184 @@
+package named_pipe_ipc
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	uuid2 "github.com/satori/go.uuid"
+)
+
+// Decoder cut the frames out of a captured byte stream the way a Context reads them, for the tools
+// which look at the traffic
+//
+// The bytes which are not a frame are returned by Next as a MalformedFrame, the stream goes on after them.
+type Decoder struct {
+	d      *decoder
+	offset int64
+	// skipped are the MalformedFrames found before the frame in pending
+	skipped []MalformedFrame
+	pending Message
+	err     error
+}
+
+// NewDecoder return a Decoder of the frames of r which end with delim
+func NewDecoder(r io.Reader, delim byte) *Decoder {
+	d := &Decoder{}
+	d.d = newDecoder(r, delim, defaultMaxFrameLength, func(skipped int, reason string) {
+		d.skipped = append(d.skipped, MalformedFrame{Offset: d.offset, Length: skipped, Reason: reason})
+		d.offset += int64(skipped)
+	})
+
+	return d
+}
+
+// Next return the next frame, the delim excluded, along with its offset in the stream
+//
+// The error is a MalformedFrame for bytes which are not a frame, and io.EOF at the end of the stream.
+// A frame cut by the end of the stream is reported as a MalformedFrame before io.EOF.
+func (d *Decoder) Next() (frame Message, offset int64, err error) {
+	if d.pending == nil && d.err == nil && len(d.skipped) == 0 {
+		d.pending, d.err = d.d.next()
+		if d.err == io.EOF && len(d.d.buf) > 0 {
+			d.skipped = append(d.skipped, MalformedFrame{Offset: d.offset, Length: len(d.d.buf), Reason: "truncated frame"})
+			d.offset += int64(len(d.d.buf))
+			d.d.buf, d.err = nil, io.EOF
+		}
+	}
+
+	if len(d.skipped) > 0 {
+		m := d.skipped[0]
+		d.skipped = d.skipped[1:]
+		return nil, m.Offset, m
+	}
+	if d.err != nil {
+		return nil, d.offset, d.err
+	}
+
+	frame, offset = d.pending, d.offset
+	d.pending = nil
+	d.offset += frame.segmentPackageLength()
+
+	return frame, offset, nil
+}
+
+// FrameInfo is what Inspect read from a frame
+type FrameInfo struct {
+	// Length is the byteLength of the frame, the delim included
+	Length int64
+	// Version is 0 for a frame of the layout before the version byte
+	Version  byte
+	Flags    []string
+	Type     byte
+	TypeName string
+	ClientID uuid2.UUID
+	TTL      time.Time
+	Headers  Metadata
+	// Payload is the content without the checksum and the MAC, still compressed or encrypted if the flags say so
+	Payload Message
+	// Problem is why a Context would drop the frame, empty if none was found
+	Problem string
+}
+
+// Expired tell if the ttl of the frame is before now
+func (fi FrameInfo) Expired(now time.Time) bool {
+	return fi.TTL.Unix() < now.Unix()
+}
+
+// Inspect describe a frame returned by a Decoder, the checksum is verified but not the MAC
+func Inspect(frame Message) FrameInfo {
+	if frame.isBaseline() {
+		return inspectBaseline(frame)
+	}
+
+	uuid, _ := frame.segmentUUID()
+	fi := FrameInfo{
+		Length:   frame.segmentPackageLength(),
+		Version:  frame.segmentVersion(),
+		Flags:    frameFlagNames(frame.segmentFrameFlags()),
+		Type:     frame.segmentType(),
+		TypeName: typeName(frame.segmentType()),
+		ClientID: uuid,
+		TTL:      time.Unix(frame.segmentTTL(), 0),
+	}
+
+	if !frame.isCompatible() {
+		fi.Problem = IncompatibleVersionMessage
+	}
+	if frame.segmentFrameFlags()&frameFlagChecksum != 0 {
+		opened, err := verifyChecksum(frame)
+		switch err.(type) {
+		case nil:
+			frame = opened
+		case MalformedFrame:
+			// the checksum is right, what it covers is not a frame: no headers nor payload to show
+			fi.Problem = "malformed after checksum"
+			return fi
+		default:
+			fi.Problem = ChecksumMismatchMessage
+			frame = withoutTrailer(frame, frameFlagChecksum, min(checksumLen, len(frame)-frame.segmentHeaderLen()-frame.segmentMetadataLen()))
+		}
+	}
+	if frame.segmentFrameFlags()&frameFlagMAC != 0 {
+		if len(frame.segmentPayload()) < macNonceLen+macLen {
+			fi.Problem = BadMACMessage
+		} else if opened, err := openTrailer(frame, frameFlagMAC, macNonceLen+macLen); err != nil {
+			fi.Problem = "malformed after MAC"
+			return fi
+		} else {
+			frame = opened
+		}
+	}
+
+	md, err := frame.segmentMetadata()
+	if err != nil {
+		fi.Problem = err.Error()
+	}
+	fi.Headers = md
+	fi.Payload = frame.segmentPayload()
+
+	return fi
+}
+
+// inspectBaseline describe a frame of the layout before the version byte
+func inspectBaseline(frame Message) FrameInfo {
+	uuid, _ := frame.baselineUUID()
+	t := frame.segmentVersion()
+
+	return FrameInfo{
+		Length:   frame.segmentPackageLength(),
+		Flags:    []string{},
+		Type:     t,
+		TypeName: typeName(t),
+		ClientID: uuid,
+		TTL:      time.Unix(frame.baselineTTL(), 0),
+		Payload:  frame[baselineHeaderLen:],
+		Problem:  IncompatibleVersionMessage,
+	}
+}
+
+// frameFlagNames name the frame flags set in flags, the unknown bits by their value
+func frameFlagNames(flags byte) []string {
+	names := []string{}
+	for _, f := range []struct {
+		bit  byte
+		name string
+	}{
+		{frameFlagRetran, "retran"},
+		{frameFlagChecksum, "checksum"},
+		{frameFlagCompressed, "compressed"},
+		{frameFlagMAC, "mac"},
+		{frameFlagEncrypted, "encrypted"},
+	} {
+		if flags&f.bit != 0 {
+			names = append(names, f.name)
+		}
+	}
+	for bit := 0; bit < 8; bit++ {
+		if b := byte(1) << bit; flags&b != 0 && knownFrameFlags&b == 0 {
+			names = append(names, fmt.Sprintf("0x%02x", b))
+		}
+	}
+
+	return names
+}
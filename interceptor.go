@@ -0,0 +1,49 @@
+package named_pipe_ipc
+
+import "context"
+
+// Invoker send or dispatch a Message, it is the end of an interceptor chain
+type Invoker func(ctx context.Context, message Message) (Message, error)
+
+// Interceptor runs around a frame and calls next to go on with the chain
+//
+// Client interceptors run around every Send, they get the payload and next returns the frame written.
+// Server interceptors run around every frame dispatched by Serve, they get the frame and next returns
+// the payload of the reply. Both run around every Recv too, Receiving tells them apart: they get a nil
+// message and next returns the frame received.
+type Interceptor func(ctx context.Context, message Message, next Invoker) (Message, error)
+
+type receivingContextKey struct{}
+
+// Receiving tell if an interceptor runs around a Recv
+func Receiving(ctx context.Context) bool {
+	receiving, _ := ctx.Value(receivingContextKey{}).(bool)
+	return receiving
+}
+
+// WithClientInterceptors append interceptors which run around every Send and Recv of a client
+func WithClientInterceptors(interceptors ...Interceptor) Option {
+	return OptionsFunc(func(o *options) {
+		o.clientInterceptors = append(o.clientInterceptors[:len(o.clientInterceptors):len(o.clientInterceptors)], interceptors...)
+	})
+}
+
+// WithServerInterceptors append interceptors which run around every frame dispatched by Serve, and every Recv
+// of a server which does not Serve
+func WithServerInterceptors(interceptors ...Interceptor) Option {
+	return OptionsFunc(func(o *options) {
+		o.serverInterceptors = append(o.serverInterceptors[:len(o.serverInterceptors):len(o.serverInterceptors)], interceptors...)
+	})
+}
+
+// chain wrap last with interceptors, the first interceptor is the outermost
+func chain(interceptors []Interceptor, last Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], last
+		last = func(ctx context.Context, message Message) (Message, error) {
+			return interceptor(ctx, message, next)
+		}
+	}
+
+	return last
+}
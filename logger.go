@@ -0,0 +1,51 @@
+package named_pipe_ipc
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithLogger set the handler which receives the log events of the Context
+//
+// Frame drops, resyncs, retransmissions, malformed frames and the open/close of the FIFOs are
+// logged at debug or warn level with the client uuid and the frame type. Nothing is logged by default.
+func WithLogger(handler slog.Handler) Option {
+	return OptionsFunc(func(o *options) {
+		o.logger = slog.New(handler)
+	})
+}
+
+var discardLogger = slog.New(discardHandler{})
+
+type discardHandler struct {
+}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool {
+	return false
+}
+
+func (discardHandler) Handle(context.Context, slog.Record) error {
+	return nil
+}
+
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h discardHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// frameAttrs describe the frame for a log event, a frame too short to have a header is only described by its size
+func frameAttrs(message Message) []any {
+	if len(message) < message.segmentHeaderLen() {
+		return []any{slog.Int("size", len(message))}
+	}
+
+	attrs := []any{slog.String("type", string(message.segmentType())), slog.Int("size", len(message))}
+	if uuid, err := message.segmentUUID(); err == nil {
+		attrs = append(attrs, slog.String("client", uuid.String()))
+	}
+
+	return attrs
+}
@@ -0,0 +1,182 @@
+package named_pipe_ipc
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Faults is what the in-memory transport of NewPair does to the frames a side writes
+//
+// The rates are probabilities between 0 and 1, Seed makes the faults reproducible.
+type Faults struct {
+	// Delay is waited before every frame is written
+	Delay time.Duration
+	// DropRate is the probability that a frame is not written
+	DropRate float64
+	// PartialRate is the probability that only the beginning of a frame is written
+	PartialRate float64
+	// CorruptRate is the probability that a byte of a frame is changed
+	CorruptRate float64
+	// ChunkSize split every frame in writes of at most ChunkSize bytes, 0 writes a frame at once
+	ChunkSize int
+	Seed      int64
+}
+
+// WithFaults make the in-memory transport of NewPair damage the frames written with faults
+func WithFaults(faults Faults) Option {
+	return OptionsFunc(func(o *options) {
+		o.faults = faults
+	})
+}
+
+// NewPair return a server and a client connected in memory, without chroot and without FIFOs
+//
+// The frames are the same as through FIFOs, opts are applied to both Contexts.
+func NewPair(ctx context.Context, opts ...Option) (server *Context, client *Context, err error) {
+	toServer, toClient := newMemPipe(), newMemPipe()
+
+	server, err = newContext(ctx, "", S, append(opts[:len(opts):len(opts)], WithTransport(&memTransport{in: toServer, out: toClient}))...)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err = newContext(ctx, "", C, append(opts[:len(opts):len(opts)], WithTransport(&memTransport{in: toClient, out: toServer}))...)
+	if err != nil {
+		server.Close()
+		return nil, nil, err
+	}
+
+	return server, client, nil
+}
+
+type memTransport struct {
+	in      *memPipe
+	out     *memPipe
+	decoder *decoder
+	faults  Faults
+	rand    *rand.Rand
+	peer    Peer
+}
+
+func (t *memTransport) Open(nctx *Context) error {
+	t.decoder = nctx.newDecoder(t.in)
+	t.faults = nctx.options.faults
+	t.rand = rand.New(rand.NewSource(t.faults.Seed))
+	t.peer = Peer{Pid: os.Getpid(), Uid: os.Geteuid(), Gid: os.Getegid()}
+
+	return nil
+}
+
+func (t *memTransport) Read() (Message, Peer, error) {
+	frame, err := t.decoder.next()
+	return frame, t.peer, err
+}
+
+// Write write frame with the faults, it is called under the write lock of the Context
+func (t *memTransport) Write(frame []byte) error {
+	f := t.faults
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	if t.happens(f.DropRate) {
+		return nil
+	}
+	if t.happens(f.CorruptRate) {
+		frame = append([]byte(nil), frame...)
+		frame[t.rand.Intn(len(frame))] ^= byte(1 + t.rand.Intn(255))
+	}
+	if t.happens(f.PartialRate) {
+		frame = frame[:t.rand.Intn(len(frame))]
+	}
+
+	for len(frame) > 0 {
+		n := len(frame)
+		if f.ChunkSize > 0 && n > f.ChunkSize {
+			n = f.ChunkSize
+		}
+		if err := t.out.write(frame[:n]); err != nil {
+			return err
+		}
+		frame = frame[n:]
+	}
+
+	return nil
+}
+
+func (t *memTransport) happens(rate float64) bool {
+	return rate > 0 && t.rand.Float64() < rate
+}
+
+// Close close the reads of this side, the other side reads io.EOF once it read what was written
+func (t *memTransport) Close() error {
+	t.in.closeRead()
+	t.out.closeWrite()
+
+	return nil
+}
+
+// memPipe is an unbounded io.Pipe, a write does not wait for the reader like with a FIFO
+type memPipe struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	buf         []byte
+	readClosed  bool
+	writeClosed bool
+}
+
+func newMemPipe() *memPipe {
+	p := &memPipe{}
+	p.cond = sync.NewCond(&p.mu)
+
+	return p
+}
+
+func (p *memPipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.buf) == 0 && !p.readClosed && !p.writeClosed {
+		p.cond.Wait()
+	}
+	if p.readClosed {
+		return 0, os.ErrClosed
+	}
+	if len(p.buf) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+
+	return n, nil
+}
+
+func (p *memPipe) write(b []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.readClosed || p.writeClosed {
+		return io.ErrClosedPipe
+	}
+	p.buf = append(p.buf, b...)
+	p.cond.Broadcast()
+
+	return nil
+}
+
+func (p *memPipe) closeRead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readClosed = true
+	p.cond.Broadcast()
+}
+
+func (p *memPipe) closeWrite() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writeClosed = true
+	p.cond.Broadcast()
+}
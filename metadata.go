@@ -0,0 +1,159 @@
+package named_pipe_ipc
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+)
+
+/**
+metadata, between the ttl and the content:
+	1byte - 2byte - (1byte - key - 2byte - value)...
+	version - blockLength - (keyLength - key - valueLength - value)...
+
+blockLength always tell where the content begin, so a reader skips the pairs of a version it does not know.
+*/
+
+const (
+	metadataVersion byte = 1
+
+	maxMetadataKeyLen   = 1<<8 - 1
+	maxMetadataValueLen = 1<<16 - 1
+	maxMetadataLen      = 1<<16 - 1
+)
+
+// Well-known header keys
+const (
+	HeaderContentType   = "content-type"
+	HeaderTenant        = "tenant"
+	HeaderAuthorization = "authorization"
+	HeaderReplyTo       = "reply-to"
+)
+
+// Metadata is the key/value section of a frame, its pairs are the headers of the Message
+type Metadata map[string]string
+
+func (md Metadata) encode() ([]byte, error) {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	block := []byte{metadataVersion, 0, 0}
+	for _, k := range keys {
+		v := md[k]
+		if len(k) > maxMetadataKeyLen || len(v) > maxMetadataValueLen {
+			return nil, MetadataTooLarge{}
+		}
+		block = append(block, byte(len(k)))
+		block = append(block, k...)
+		block = binary.BigEndian.AppendUint16(block, uint16(len(v)))
+		block = append(block, v...)
+	}
+	if len(block)-3 > maxMetadataLen {
+		return nil, MetadataTooLarge{}
+	}
+	binary.BigEndian.PutUint16(block[1:], uint16(len(block)-3))
+
+	return block, nil
+}
+
+func decodeMetadata(version byte, block []byte) (Metadata, error) {
+	if version != metadataVersion {
+		return nil, UnsupportedMetadataVersion{}
+	}
+
+	md := make(Metadata)
+	for len(block) > 0 {
+		kl := int(block[0])
+		if len(block) < 1+kl+2 {
+			return nil, MessageNotLegal{}
+		}
+		k := string(block[1 : 1+kl])
+		block = block[1+kl:]
+
+		vl := int(binary.BigEndian.Uint16(block))
+		if len(block) < 2+vl {
+			return nil, MessageNotLegal{}
+		}
+		md[k] = string(block[2 : 2+vl])
+		block = block[2+vl:]
+	}
+
+	return md, nil
+}
+
+func (M Message) segmentMetadataLen() int {
+	return 3 + int(binary.BigEndian.Uint16(M[M.segmentHeaderLen()+1:]))
+}
+
+func (M Message) segmentMetadata() (Metadata, error) {
+	if len(M) < M.segmentHeaderLen()+3 || len(M) < M.segmentHeaderLen()+M.segmentMetadataLen() {
+		return nil, MessageNotLegal{}
+	}
+
+	return decodeMetadata(M[M.segmentHeaderLen()], M[M.segmentHeaderLen()+3:M.segmentHeaderLen()+M.segmentMetadataLen()])
+}
+
+// Headers return the headers of the frame, nil when the frame has none that can be read
+func (M Message) Headers() Metadata {
+	md, err := M.segmentMetadata()
+	if err != nil {
+		return nil
+	}
+
+	return md
+}
+
+// Header return the value of the header key, an empty string if there is none
+func (M Message) Header(key string) string {
+	return M.Headers()[key]
+}
+
+// SetHeader return a copy of the frame with the header key set to value
+func (M Message) SetHeader(key, value string) (Message, error) {
+	md, err := M.segmentMetadata()
+	if err != nil {
+		return nil, err
+	}
+	md[key] = value
+
+	return M.withMetadata(md)
+}
+
+// withMetadata return a copy of the frame with its metadata replaced by md
+func (M Message) withMetadata(md Metadata) (Message, error) {
+	return M.rebuild(md, M.segmentPayload())
+}
+
+// rebuild return a copy of the frame with its metadata and its payload replaced
+func (M Message) rebuild(md Metadata, payload []byte) (Message, error) {
+	block, err := md.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(Message, 0, M.segmentHeaderLen()+len(block)+len(payload))
+	m = append(m, M[:M.segmentHeaderLen()]...)
+	m = append(m, block...)
+	m = append(m, payload...)
+
+	// the package length keep counting what is not in M, like the delim
+	length := M.segmentPackageLength() - int64(len(M)) + int64(len(m))
+	binary.BigEndian.PutUint64(m, uint64(length))
+
+	return m, nil
+}
+
+type headersContextKey struct{}
+
+// ContextWithHeaders return a copy of ctx, the frames sent by SendContext/CallContext with it carry headers
+func ContextWithHeaders(ctx context.Context, headers Metadata) context.Context {
+	return context.WithValue(ctx, headersContextKey{}, headers)
+}
+
+func headersFromContext(ctx context.Context) Metadata {
+	headers, _ := ctx.Value(headersContextKey{}).(Metadata)
+	return headers
+}
@@ -0,0 +1,117 @@
+package named_pipe_ipc
+
+import (
+	"log/slog"
+	"os"
+	"syscall"
+)
+
+const anyOwner = -1
+
+// WithFifoMode set the permissions of the FIFOs created by the server, the FIFOs it reuses may not allow more
+func WithFifoMode(mode os.FileMode) Option {
+	return OptionsFunc(func(o *options) {
+		o.fifoMode = mode.Perm()
+	})
+}
+
+// WithFifoOwner set the owner of the FIFOs of the server, -1 keep the current user or group
+//
+// The server gives the FIFOs it creates to uid and gid, and refuses to reuse FIFOs owned by someone else.
+func WithFifoOwner(uid, gid int) Option {
+	return OptionsFunc(func(o *options) {
+		o.fifoUid = uid
+		o.fifoGid = gid
+	})
+}
+
+// WithExpectedOwner make a client check that the FIFOs of the server are owned by uid before it opens them
+func WithExpectedOwner(uid int) Option {
+	return OptionsFunc(func(o *options) {
+		o.expectedOwner = uid
+	})
+}
+
+// ownerOf return the uid and the gid the FIFOs of the server must have
+func (o *options) ownerOf() (uid, gid int) {
+	uid, gid = o.fifoUid, o.fifoGid
+	if uid == anyOwner {
+		uid = os.Geteuid()
+	}
+	if gid == anyOwner {
+		gid = os.Getegid()
+	}
+
+	return uid, gid
+}
+
+// makeFifo create the FIFO path with the mode and the owner of the options
+func (nctx *Context) makeFifo(path string) error {
+	if err := syscall.Mkfifo(path, uint32(nctx.options.fifoMode)); err != nil {
+		return err
+	}
+	// Mkfifo applies the umask of the process
+	if err := nctx.setOwner(path); err != nil {
+		return err
+	}
+
+	nctx.logger.Debug("fifo created", slog.String("path", path), slog.String("mode", nctx.options.fifoMode.String()))
+	return nil
+}
+
+// setOwner give path the mode and the owner of the options
+func (nctx *Context) setOwner(path string) error {
+	if err := os.Chmod(path, nctx.options.fifoMode); err != nil {
+		return err
+	}
+	if nctx.options.fifoUid != anyOwner || nctx.options.fifoGid != anyOwner {
+		return os.Chown(path, nctx.options.fifoUid, nctx.options.fifoGid)
+	}
+
+	return nil
+}
+
+// verifyFifo check that path is a FIFO owned by uid, and by gid unless it is anyOwner, with no more permissions than the options
+func (nctx *Context) verifyFifo(path string, uid, gid int) error {
+	s, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if s.Mode().Type() != os.ModeNamedPipe {
+		return AlreadyExistButNotNamedPipe{}
+	}
+
+	return nctx.verifyOwner(path, s, uid, gid)
+}
+
+// verifyOwner check that the file s at path is owned by uid and gid with no more permissions than the options
+func (nctx *Context) verifyOwner(path string, s os.FileInfo, uid, gid int) error {
+	st, ok := s.Sys().(*syscall.Stat_t)
+	if !ok {
+		return UnexpectedOwner{}
+	}
+	if int(st.Uid) != uid || (gid != anyOwner && int(st.Gid) != gid) {
+		nctx.logger.Warn("file of unexpected owner", slog.String("path", path), slog.Int("uid", int(st.Uid)), slog.Int("gid", int(st.Gid)))
+		return UnexpectedOwner{}
+	}
+	if s.Mode().Perm()&^nctx.options.fifoMode != 0 {
+		nctx.logger.Warn("file of permissive mode", slog.String("path", path), slog.String("mode", s.Mode().Perm().String()))
+		return PermissiveMode{}
+	}
+
+	return nil
+}
+
+// fifoPeer return the owner of the FIFO path as the peer which writes to it
+func fifoPeer(path string) Peer {
+	s, err := os.Stat(path)
+	if err != nil {
+		return unknownPeer
+	}
+	st, ok := s.Sys().(*syscall.Stat_t)
+	if !ok {
+		return unknownPeer
+	}
+
+	return Peer{Pid: -1, Uid: int(st.Uid), Gid: int(st.Gid)}
+}
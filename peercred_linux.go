@@ -0,0 +1,24 @@
+package named_pipe_ipc
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials return the process at the other end of conn, from SO_PEERCRED
+func peerCredentials(conn *net.UnixConn) Peer {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return unknownPeer
+	}
+
+	peer := unknownPeer
+	_ = raw.Control(func(fd uintptr) {
+		cred, err := syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+		if err == nil {
+			peer = Peer{Pid: int(cred.Pid), Uid: int(cred.Uid), Gid: int(cred.Gid)}
+		}
+	})
+
+	return peer
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package named_pipe_ipc
+
+import (
+	"net"
+)
+
+// peerCredentials return unknownPeer, SO_PEERCRED is only read on linux
+func peerCredentials(conn *net.UnixConn) Peer {
+	return unknownPeer
+}
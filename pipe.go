@@ -1,42 +1,115 @@
 package named_pipe_ipc
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	uuid2 "github.com/satori/go.uuid"
 	"io"
+	"log/slog"
+	"net"
 	"os"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	defaultUmask             = 0600
+	defaultFifoMode          = 0600
 	defaultDelim             = '\n'
+	defaultTTL               = 10 * time.Second
 	defaultNamedPipeForRead  = "golang.pipe.1.r"
 	defaultNamedPipeForWrite = "golang.pipe.1.w"
 )
 
 const (
+	protoVersion byte = 1
+
 	protoNormalType   byte = '0'
 	protoResponseType byte = '1'
-	protoRetranType   byte = '2'
-	protoFlag              = "named-pipe-ipc"
+	// protoRetranType was the type of a retransmitted frame before the version byte, frameFlagRetran replaced it
+	protoRetranType byte = '2'
+	protoErrorType  byte = '3'
+	protoHelloType  byte = '4'
+	protoAuthType   byte = '5'
+	protoFlag            = "named-pipe-ipc"
+)
+
+// frame flags, the features used by a frame
+const (
+	// frameFlagRetran mark a frame sent back to the server by a client it does not belong to
+	frameFlagRetran byte = 1 << iota
+	// frameFlagChecksum mark a frame followed by the CRC32C of everything before it
+	frameFlagChecksum
+	// frameFlagCompressed mark a frame whose content is compressed with its content-encoding header
+	frameFlagCompressed
+	// frameFlagMAC mark a frame followed by a nonce and the HMAC of everything before it
+	frameFlagMAC
+	// frameFlagEncrypted mark a frame whose content is sealed with the key of its client
+	frameFlagEncrypted
+
+	knownFrameFlags = frameFlagRetran | frameFlagChecksum | frameFlagCompressed | frameFlagMAC | frameFlagEncrypted
 )
 
 var defaultOption = &options{
-	defaultDelim,
-	defaultNamedPipeForRead,
-	defaultNamedPipeForWrite,
+	delim:             defaultDelim,
+	namedPipeForRead:  defaultNamedPipeForRead,
+	namedPipeForWrite: defaultNamedPipeForWrite,
+	ttl:               defaultTTL,
+	workers:           1,
+	logger:            discardLogger,
+	maxFrameLength:    defaultMaxFrameLength,
+	fifoMode:          defaultFifoMode,
+	fifoUid:           anyOwner,
+	fifoGid:           anyOwner,
+	expectedOwner:     anyOwner,
 }
 
 type options struct {
 	delim             byte
 	namedPipeForRead  string
 	namedPipeForWrite string
+	ttl               time.Duration
+
+	workers        int
+	clientOrdering bool
+
+	clientInterceptors []Interceptor
+	serverInterceptors []Interceptor
+
+	logger *slog.Logger
+
+	tracePropagator TracePropagator
+	replyHeaders    []string
+
+	features []string
+
+	checksum bool
+
+	maxFrameLength int64
+	maxMessageSize int64
+
+	compressor      Compressor
+	compressMinSize int
+
+	sharedSecret []byte
+
+	encryption    bool
+	encryptionKey []byte
+
+	authenticator Authenticator
+	policy        Policy
+
+	transport Transport
+	faults    Faults
+	recorder  io.Writer
+
+	fifoMode      os.FileMode
+	fifoUid       int
+	fifoGid       int
+	expectedOwner int
 }
 
 type Option interface {
@@ -67,10 +140,30 @@ func WithDelim(delim byte) Option {
 	})
 }
 
+// WithTTL set how long the frames of a client live, 10 seconds by default
+func WithTTL(ttl time.Duration) Option {
+	return OptionsFunc(func(o *options) {
+		o.ttl = ttl
+	})
+}
+
+// WithMaxMessageSize set the greatest frame, byteLength included, a Context sends or accepts
+//
+// Sending a larger frame fails with TooLarge before anything is written, a larger frame received is
+// skipped and the server replies TooLarge to its client.
+func WithMaxMessageSize(n int64) Option {
+	return OptionsFunc(func(o *options) {
+		o.maxMessageSize = n
+	})
+}
+
 /**
 protocol:
-	8byte - 14byte - 1byte - 16byte - 8byte - string
-	byteLength - flag - type - uuid  - ttl - content
+	8byte - 14byte - 1byte - 1byte - 1byte - 16byte - 8byte - 3byte+ - string
+	byteLength - flag - version - frameFlags - type - uuid  - ttl - metadata - content
+
+byteLength, flag, version, frameFlags, type and uuid keep their place in every version,
+so that a peer can always tell who sent a frame of a version it does not know.
 */
 
 type Message []byte
@@ -87,6 +180,14 @@ func (M Message) segmentPackageLengthLen() int {
 	return 8
 }
 
+func (M Message) segmentVersionLen() int {
+	return 1
+}
+
+func (M Message) segmentFrameFlagsLen() int {
+	return 1
+}
+
 func (M Message) segmentTypeLen() int {
 	return 1
 }
@@ -103,6 +204,30 @@ func (M Message) segmentTTLLen() int {
 	return 8
 }
 
+func (M Message) segmentVersionOffset() int {
+	return M.segmentPackageLengthLen() + M.segmentFlagLen()
+}
+
+func (M Message) segmentFrameFlagsOffset() int {
+	return M.segmentVersionOffset() + M.segmentVersionLen()
+}
+
+func (M Message) segmentTypeOffset() int {
+	return M.segmentFrameFlagsOffset() + M.segmentFrameFlagsLen()
+}
+
+func (M Message) segmentUUIDOffset() int {
+	return M.segmentTypeOffset() + M.segmentTypeLen()
+}
+
+func (M Message) segmentTTLOffset() int {
+	return M.segmentUUIDOffset() + M.segmentUUIDLen()
+}
+
+func (M Message) segmentHeaderLen() int {
+	return M.segmentTTLOffset() + M.segmentTTLLen()
+}
+
 func (M Message) segmentPackageLength() int64 {
 	return int64(binary.BigEndian.Uint64(M[0:M.segmentPackageLengthLen()]))
 }
@@ -113,48 +238,106 @@ func (M Message) segmentFlag() (flag []byte) {
 	return flag
 }
 
+func (M Message) segmentVersion() byte {
+	return M[M.segmentVersionOffset()]
+}
+
+func (M Message) segmentFrameFlags() byte {
+	return M[M.segmentFrameFlagsOffset()]
+}
+
 func (M Message) segmentType() (t byte) {
-	t = M[M.segmentPackageLengthLen()+M.segmentFlagLen() : M.segmentPackageLengthLen()+M.segmentFlagLen()+M.segmentTypeLen()].Byte()[0]
+	t = M[M.segmentTypeOffset()]
 
 	return t
 }
 
 func (M Message) segmentUUID() (uuid uuid2.UUID, err error) {
-	uuid, err = uuid2.FromBytes(M[M.segmentPackageLengthLen()+M.segmentFlagLen()+M.segmentTypeLen() : M.segmentPackageLengthLen()+M.segmentFlagLen()+M.segmentTypeLen()+M.segmentUUIDLen()])
+	uuid, err = uuid2.FromBytes(M[M.segmentUUIDOffset() : M.segmentUUIDOffset()+M.segmentUUIDLen()])
 	return
 }
 
 func (M Message) segmentTTL() (ttl int64) {
-	timestamp := M[M.segmentPackageLengthLen()+M.segmentFlagLen()+M.segmentTypeLen()+M.segmentUUIDLen() : M.segmentPackageLengthLen()+M.segmentFlagLen()+M.segmentTypeLen()+M.segmentUUIDLen()+M.segmentTTLLen()]
+	timestamp := M[M.segmentTTLOffset() : M.segmentTTLOffset()+M.segmentTTLLen()]
 	ttl = int64(binary.BigEndian.Uint64(timestamp))
 
 	return
 }
 
 func (M Message) segmentPayload() Message {
-	return M[M.segmentPackageLengthLen()+M.segmentFlagLen()+M.segmentTypeLen()+M.segmentUUIDLen()+M.segmentTTLLen():]
+	return M[M.segmentHeaderLen()+M.segmentMetadataLen():]
 }
 
 func (M Message) Payload() Message {
 	return M.segmentPayload()
 }
 
+// isWellFormed tell if the frame is long enough for its header and its metadata
+func (M Message) isWellFormed() bool {
+	return len(M) >= M.segmentHeaderLen()+3 && len(M) >= M.segmentHeaderLen()+M.segmentMetadataLen()
+}
+
 func (M Message) isLegal() bool {
 	return bytes.Equal(M.segmentFlag(), []byte(protoFlag))
 }
 
+// isCompatible tell if the frame is of our version and only use frame flags we know
+func (M Message) isCompatible() bool {
+	return M.segmentVersion() == protoVersion && M.segmentFrameFlags()&^knownFrameFlags == 0
+}
+
 func (M Message) isRetran() bool {
-	return M[M.segmentPackageLengthLen()+M.segmentFlagLen()+M.segmentTypeLen()-1] == protoRetranType
+	return M.segmentFrameFlags()&frameFlagRetran != 0
 }
 
 func (M Message) changeRetran() {
-	M[M.segmentPackageLengthLen()+M.segmentFlagLen()+M.segmentTypeLen()-1] = protoRetranType
+	M[M.segmentFrameFlagsOffset()] |= frameFlagRetran
+}
+
+func (M Message) clearRetran() {
+	M[M.segmentFrameFlagsOffset()] &^= frameFlagRetran
+}
+
+func (M Message) isError() bool {
+	return M.segmentType() == protoErrorType
+}
+
+// ResponsePayload build the reply frame of M, the headers copyHeaders of M are copied into it
+func (M Message) ResponsePayload(message Message, copyHeaders ...string) Message {
+	return M.reply(protoResponseType, message, M.copyHeaders(copyHeaders))
+}
+
+// ErrorPayload build an error frame for the client of M
+//
+// The client side Recv return the frame together with the error
+func (M Message) ErrorPayload(err error, copyHeaders ...string) Message {
+	return M.reply(protoErrorType, Message(err.Error()), M.copyHeaders(copyHeaders))
+}
+
+func (M Message) copyHeaders(keys []string) Metadata {
+	metadata := make(Metadata)
+	if len(keys) > 0 {
+		headers := M.Headers()
+		for _, k := range keys {
+			if v, ok := headers[k]; ok {
+				metadata[k] = v
+			}
+		}
+	}
+
+	return metadata
 }
 
-func (M Message) ResponsePayload(message Message) Message {
+func (M Message) reply(t byte, message Message, metadata Metadata) Message {
+	// the headers come from M or from us, they always fit
+	block, _ := metadata.encode()
+
 	ma := make([]byte, 0)
-	ma = append(ma, M[M.segmentPackageLengthLen():M.segmentPackageLengthLen()+M.segmentFlagLen()+M.segmentTypeLen()+M.segmentUUIDLen()+M.segmentTTLLen()]...)
-	ma[M.segmentFlagLen()+M.segmentTypeLen()-1] = protoResponseType
+	ma = append(ma, M[M.segmentPackageLengthLen():M.segmentHeaderLen()]...)
+	ma[M.segmentVersionOffset()-M.segmentPackageLengthLen()] = protoVersion
+	ma[M.segmentFrameFlagsOffset()-M.segmentPackageLengthLen()] = 0
+	ma[M.segmentTypeOffset()-M.segmentPackageLengthLen()] = t
+	ma = append(ma, block...)
 	ma = append(ma, message.Byte()...)
 	packageLengthBuf := make([]byte, 8)
 	// package-buf's length + delim's length
@@ -170,11 +353,15 @@ type Context struct {
 	out  chan Message
 	role RoleType
 
-	delim byte
-	rPipe *os.File
-	wPipe *os.File
-	br    *bufio.Reader
-	bw    *bufio.Writer
+	delim     byte
+	transport Transport
+	wmu       sync.Mutex
+
+	options  options
+	logger   *slog.Logger
+	stats    *stats
+	replay   *replayCache
+	recorder *recorder
 
 	context           context.Context
 	chroot            string
@@ -182,64 +369,17 @@ type Context struct {
 	namedPipeForWrite string
 
 	clientID uuid2.UUID
-}
-
-func createFifo(nctx *Context) (err error) {
-	if ex, err := Exists(nctx.namedPipeForReadFullPath()); err != nil {
-		return err
-	} else {
-		if !ex {
-			err = syscall.Mkfifo(nctx.namedPipeForReadFullPath(), defaultUmask)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	if ex, err := Exists(nctx.namedPipeForWriteFullPath()); err != nil {
-		return err
-	} else {
-		if !ex {
-			err = syscall.Mkfifo(nctx.namedPipeForWriteFullPath(), defaultUmask)
-			if err != nil {
-				return err
-			}
-		}
-	}
 
-	return nil
-}
-
-// openPipeFile
-//
-// Why use os.RDWR? not os.RD_ONLY or os.WD_ONLY ?
-//
-// When the FIFO is turned on, the non-blocking flag (O_NONBLOCK) has the following effects:
-// i. O_NONBLOCK is not specified (i.e. open has no bit or O_NONBLOCK).
-// 	2. When a FIFO is opened in read-only mode, the FIFO is blocked until a process opens the FIFO for writing
-// 	3. When the FIFO is opened in write-only mode, it is blocked until a process opens the FIFO for reads.
-// 	4. When the FIFO is opened in read-only, write-only mode, it blocks. When the read function is called to read data from the FIFO, the read function also blocks.
-// 	4, Call the write function to write data to the FIFO, and write will block when the buffer is full.
-// 	5, communication process if the writing process first quit, then call the read function to read data from the FIFO does not block; If the writing process starts again, the read function is called to read data from the FIFO.
-// 	6. During the communication process, when the reader process exits and the writer process writes data to the named pipe, the writer process will also exit (receiving SIGPIPE signal).
-// If no process has opened a FIFO for write, read - only open succeeds, and open is not blocked.
-// ii. Specify O_NONBLOCK(that is, open bit or O_NONBLOCK)
-// 	1. If no process has opened a FIFO for read, writing only open will return -1.
-// 	2. Named pipes do not block when reading data.
-//  3. During the communication process, when the reader process exits and the writer process writes data to the named pipe, the writer process will also exit (receiving SIGPIPE signal).
-func openPipeFile(nctx *Context) (err error) {
-	nctx.rPipe, err = os.OpenFile(nctx.namedPipeForReadFullPath(), os.O_RDWR, os.ModeNamedPipe)
-	if err != nil {
-		return err
-	}
-	nctx.wPipe, err = os.OpenFile(nctx.namedPipeForWriteFullPath(), os.O_RDWR, os.ModeNamedPipe)
-	if err != nil {
-		return err
-	}
-
-	nctx.br = bufio.NewReader(nctx.rPipe)
-	nctx.bw = bufio.NewWriter(nctx.wPipe)
-
-	return nil
+	// what a client agreed on with the server, or the server with each client
+	peerMu sync.RWMutex
+	peer   Capabilities
+	peers  map[uuid2.UUID]Capabilities
+	// a client waits for the reply to its hello, a baseline server answers it in the layout before the version byte
+	handshaking atomic.Bool
+	// the keys, the sessions and the pending challenges of each client, also guarded by peerMu
+	aeads      map[uuid2.UUID]*aeadState
+	sessions   map[uuid2.UUID]Session
+	challenges map[uuid2.UUID][]byte
 }
 
 func NewContext(ctx context.Context, chroot string, role RoleType, opts ...Option) (*Context, error) {
@@ -251,21 +391,34 @@ func NewContext(ctx context.Context, chroot string, role RoleType, opts ...Optio
 		chroot += "/"
 	}
 
-	for _, o := range opts {
-		o.apply(defaultOption)
-	}
+	return newContext(ctx, chroot, role, opts...)
+}
 
-	nctx := &Context{
+// newContext set up a Context and open its transport, the chroot is not checked
+func newContext(ctx context.Context, chroot string, role RoleType, opts ...Option) (nctx *Context, err error) {
+	o := *defaultOption
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	nctx = &Context{
 		role:              role,
 		chroot:            chroot,
-		delim:             defaultOption.delim,
-		namedPipeForRead:  defaultOption.namedPipeForRead,
-		namedPipeForWrite: defaultOption.namedPipeForWrite,
+		options:           o,
+		logger:            o.logger,
+		stats:             newStats(),
+		replay:            newReplayCache(),
+		peers:             make(map[uuid2.UUID]Capabilities),
+		aeads:             make(map[uuid2.UUID]*aeadState),
+		sessions:          make(map[uuid2.UUID]Session),
+		challenges:        make(map[uuid2.UUID][]byte),
+		delim:             o.delim,
+		namedPipeForRead:  o.namedPipeForRead,
+		namedPipeForWrite: o.namedPipeForWrite,
 	}
 
 	if nctx.role == C {
-		nctx.namedPipeForWrite = defaultOption.namedPipeForRead
-		nctx.namedPipeForRead = defaultOption.namedPipeForWrite
+		nctx.namedPipeForWrite = o.namedPipeForRead
+		nctx.namedPipeForRead = o.namedPipeForWrite
 
 		for {
 			nctx.clientID = uuid2.NewV4()
@@ -278,15 +431,25 @@ func NewContext(ctx context.Context, chroot string, role RoleType, opts ...Optio
 	nctx.context = ctx
 	nctx.out = make(chan Message, 10)
 
-	if nctx.role == S {
-		err := createFifo(nctx)
-		if err != nil {
+	if o.recorder != nil {
+		if nctx.recorder, err = newRecorder(o.recorder, role, nctx.delim); err != nil {
 			return nil, err
 		}
 	}
 
-	err := openPipeFile(nctx)
-	if err != nil {
+	nctx.transport = o.transport
+	if nctx.transport == nil {
+		nctx.transport = &fifoTransport{}
+	}
+	if role == S && o.authenticator != nil && !o.encryption && !verifiesPeers(nctx.transport) {
+		// a session is bound to the keys of its client, the uuid alone is seen by every client of the FIFOs
+		return nil, InsecureAuthenticator{}
+	}
+	if role == S && usesUid(o.policy) && !verifiesPeers(nctx.transport) {
+		// the owner of a FIFO is not the client which writes to it
+		return nil, UnverifiedUid{}
+	}
+	if err = nctx.transport.Open(nctx); err != nil {
 		return nil, err
 	}
 
@@ -319,56 +482,142 @@ func (nctx *Context) Chroot() string {
 // the send()/ recv() combination is more acceptable
 func (nctx *Context) Send(message Message) (int, error) {
 	if nctx.role == S {
-		if !message.isLegal() {
+		if !message.isWellFormed() || !message.isLegal() || !message.isCompatible() {
+			nctx.stats.malformed.Add(1)
+			nctx.logger.Warn("malformed frame not sent", frameAttrs(message)...)
 			return 0, new(MessageNotLegal)
 		}
-		return nctx.directlySend(append(message, nctx.delim))
+		return nctx.directlySend(message)
+	}
+
+	return nctx.SendContext(nctx.context, message)
+}
+
+// SendContext send the payload message of a client, the headers and the trace of ctx are carried by the frame
+func (nctx *Context) SendContext(ctx context.Context, message Message) (int, error) {
+	if nctx.role != C {
+		return 0, NotClientRole{}
 	}
+
+	frame, err := chain(nctx.options.clientInterceptors, nctx.send)(ctx, message)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(frame), nil
+}
+
+// send build the frame of the payload message and write it
+func (nctx *Context) send(ctx context.Context, message Message) (Message, error) {
+	metadata := make(Metadata)
+	for k, v := range headersFromContext(ctx) {
+		metadata[k] = v
+	}
+	if nctx.options.tracePropagator != nil {
+		nctx.options.tracePropagator.Inject(ctx, metadata)
+	}
+	protocol, err := nctx.frame(protoNormalType, metadata, message)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := nctx.directlySend(protocol); err != nil {
+		return nil, err
+	}
+
+	return protocol, nil
+}
+
+// frame build a frame of the client with the type t
+func (nctx *Context) frame(t byte, metadata Metadata, message Message) (Message, error) {
+	block, err := metadata.encode()
+	if err != nil {
+		return nil, err
+	}
+
 	buf := make([]byte, 0, 0)
 	// flag
 	buf = append(buf, []byte(protoFlag)...)
+	// version
+	buf = append(buf, protoVersion)
+	// frame flags
+	buf = append(buf, 0)
 	// type
-	buf = append(buf, protoNormalType)
+	buf = append(buf, t)
 	// uuid
 	buf = append(buf, nctx.clientID.Bytes()...)
-	// ttl 30 second
-	ttl := time.Now().Unix() + 10
+	// ttl
+	ttl := time.Now().Add(nctx.options.ttl).Unix()
 	timeBuf := make([]byte, 8)
 	binary.BigEndian.PutUint64(timeBuf, uint64(ttl))
 	buf = append(buf, timeBuf...)
+	// metadata
+	buf = append(buf, block...)
 	// content
-	buf = append(buf, append(message, nctx.delim).Byte()...)
-	// package length
+	buf = append(buf, message.Byte()...)
+	// package length, the delim is written by directlySend
 	packageLengthBuf := make([]byte, 8)
-	binary.BigEndian.PutUint64(packageLengthBuf, uint64(len(buf)+8))
+	binary.BigEndian.PutUint64(packageLengthBuf, uint64(len(buf)+8+1))
 
 	protocol := make([]byte, 0, len(packageLengthBuf)+len(buf))
 	protocol = append(protocol, packageLengthBuf...)
 	protocol = append(protocol, buf...)
 
-	nn, err := nctx.bw.Write(protocol)
-	if err != nil {
-		return 0, nil
-	}
-	err = nctx.bw.Flush()
+	return protocol, nil
+}
+
+// directlySend seal the frame message and write it followed by the delim
+func (nctx *Context) directlySend(message Message) (int, error) {
+	message, err := nctx.sealFrame(message)
 	if err != nil {
 		return 0, err
 	}
 
-	return nn, nil
+	return nctx.write(message)
 }
 
-func (nctx *Context) directlySend(message Message) (int, error) {
-	nn, err := nctx.bw.Write(message)
-	if err != nil {
-		return 0, nil
+// write write a sealed frame followed by the delim
+func (nctx *Context) write(message Message) (int, error) {
+	message = append(message, nctx.delim)
+	if nctx.options.maxMessageSize > 0 && int64(len(message)) > nctx.options.maxMessageSize {
+		return 0, TooLarge{}
 	}
-	err = nctx.bw.Flush()
-	if err != nil {
+
+	nctx.wmu.Lock()
+	defer nctx.wmu.Unlock()
+
+	if err := nctx.transport.Write(message); err != nil {
 		return 0, err
 	}
+	nctx.stats.sent(message)
+	nctx.record(RecordSent, message[:len(message)-1])
+
+	return len(message), nil
+}
+
+// Call Message
+//
+// Call send message and wait for the reply of the server, only a client can Call
+func (nctx *Context) Call(message Message) (Message, error) {
+	return nctx.CallContext(nctx.context, message)
+}
+
+// CallContext is Call with the trace of ctx carried by the frame
+func (nctx *Context) CallContext(ctx context.Context, message Message) (Message, error) {
+	if nctx.role != C {
+		return nil, NotClientRole{}
+	}
+
+	start := time.Now()
+	defer func() {
+		nctx.stats.callLatency.observe(time.Since(start))
+	}()
+
+	if _, err := nctx.SendContext(ctx, message); err != nil {
+		return nil, err
+	}
 
-	return nn, nil
+	return nctx.Recv(true)
 }
 
 // Recv Message
@@ -376,6 +625,19 @@ func (nctx *Context) directlySend(message Message) (int, error) {
 // This API should work best with Read, but since most people are web developers
 // the send()/ recv() combination is more acceptable
 func (nctx *Context) Recv(block bool) (Message, error) {
+	interceptors := nctx.options.clientInterceptors
+	if nctx.role == S {
+		interceptors = nctx.options.serverInterceptors
+	}
+	ctx := context.WithValue(nctx.context, receivingContextKey{}, true)
+
+	return chain(interceptors, func(ctx context.Context, _ Message) (Message, error) {
+		return nctx.recv(block)
+	})(ctx, nil)
+}
+
+// recv return the next frame without the interceptors, for Serve and the replies of the Handshake and Connect
+func (nctx *Context) recv(block bool) (Message, error) {
 	if nctx.role == S {
 		if !block {
 			if len(nctx.out) == 0 {
@@ -394,6 +656,9 @@ func (nctx *Context) Recv(block bool) (Message, error) {
 				}
 
 				if msg.isRetran() {
+					nctx.stats.retransmissions.Add(1)
+					nctx.logger.Debug("retransmit frame", frameAttrs(msg)...)
+					msg.clearRetran()
 					_, err := nctx.Send(msg)
 					if err != nil {
 						return nil, err
@@ -412,148 +677,177 @@ func (nctx *Context) Recv(block bool) (Message, error) {
 		ok := make(chan bool, 1)
 
 		go func() {
-		ResetReadBytes:
-			var bfs = make([]Message, 0)
-			var expectLength int64 = 0
-		ReadBytes:
-			bf, err = nctx.br.ReadBytes(nctx.delim)
-			if err != nil && err != io.EOF {
-				if pe, ok := err.(*os.PathError); ok {
-					if pe.Err == os.ErrClosed {
-						bf = nil
-						err = Closed{}
-						return
-					}
-				}
-
-				bf = nil
-				return
-			}
-
-			if bf != nil {
-				if expectLength == 0 {
-					expectLength = bf.segmentPackageLength()
-					bfs = append(bfs, bf)
-				} else {
-					bfs = append(bfs, bf)
-				}
-
-				var sum int64 = 0
-				for _, tbf := range bfs {
-					sum += int64(len(tbf.Byte()))
-				}
+			defer func() {
+				ok <- true
+			}()
 
-				if sum != expectLength {
-					if sum > expectLength {
-						goto ResetReadBytes
+			for {
+				message, rerr := nctx.readOwnFrame()
+				if rerr != nil {
+					bf, err = nil, rerr
+					if isClosed(rerr) {
+						err = Closed{}
 					}
-					goto ReadBytes
-				}
-
-				var buf Message
-				for _, tbf := range bfs {
-					buf = append(buf, tbf.Byte()...)
-				}
-
-				message := buf
-				uuid, err := message.segmentUUID()
-				if err != nil {
 					return
 				}
-				if message.segmentTTL() < time.Now().Unix() {
-					// drop message
-					goto ReadBytes
-				}
 
-				if uuid != nctx.clientID {
-					// resend message to server
-					message.changeRetran()
-					_, err = nctx.directlySend(message)
-					if err != nil {
-						return
-					}
-					goto ReadBytes
+				if message, rerr = nctx.deliver(message); rerr != nil {
+					continue
 				}
-
-				// read not include nctx.delim
-				bf = buf[:len(buf)-1]
-				ok <- true
+				bf = message
+				return
 			}
 		}()
 
 		for {
 			select {
 			case <-nctx.context.Done():
-				err = nctx.close()
-				return nil, HybridError{nctx.context.Err(), err}
+				cerr := nctx.close()
+				return nil, HybridError{nctx.context.Err(), cerr}
 			case <-ok:
+				if err != nil {
+					return nil, err
+				}
+				if bf.isError() {
+					return bf, remoteError(bf.Payload())
+				}
 				return bf, nil
 			}
 		}
 	}
 }
 
+// readOwnFrame return the next frame of the client, it drops the expired frames and sends back to the server
+// the frames of the other clients
+func (nctx *Context) readOwnFrame() (Message, error) {
+	for {
+		message, _, err := nctx.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		uuid, _ := message.segmentUUID()
+		if message.segmentTTL() < time.Now().Unix() {
+			// drop message
+			nctx.stats.droppedExpired.Add(1)
+			nctx.logger.Warn("frame dropped, ttl expired", append(frameAttrs(message), slog.Int64("ttl", message.segmentTTL()))...)
+			continue
+		}
+
+		if uuid != nctx.clientID {
+			// resend message to server
+			nctx.stats.retransmissions.Add(1)
+			nctx.logger.Debug("frame of another client, retransmit", frameAttrs(message)...)
+			message.changeRetran()
+			if _, err = nctx.directlySend(message); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return message, nil
+	}
+}
+
 // Listen Message
 func (nctx *Context) Listen() error {
-	var err error
-	var bf Message
-	var bfs = make([]Message, 0)
-	var expectLength int64 = 0
-	for err == nil {
+	// Recv return Closed once Listen is over
+	defer close(nctx.out)
+	for {
 		select {
 		case <-nctx.context.Done():
-			close(nctx.out)
 			return nil
 		default:
 			if nctx.context.Err() != nil {
-				close(nctx.out)
 				return nctx.context.Err()
 			}
 		}
 
-		bf, err = nctx.br.ReadBytes(nctx.delim)
-		if err != nil && err != io.EOF {
-			if pe, ok := err.(*os.PathError); ok {
-				if pe.Err == os.ErrClosed {
-					return nil
-				}
+		frame, peer, err := nctx.readFrame()
+		if err != nil {
+			if isClosed(err) || err == io.EOF {
+				return nil
 			}
 
 			return err
 		}
 
-		if bf != nil {
-			if expectLength == 0 {
-				expectLength = bf.segmentPackageLength()
-				bfs = append(bfs, bf)
-			} else {
-				bfs = append(bfs, bf)
-			}
+		// a retransmitted frame is one of ours, it is sent again as it came, even to a client without a session yet
+		if frame.isRetran() {
+			nctx.out <- frame
+			continue
+		}
+		if frame.segmentType() == protoHelloType {
+			nctx.hello(frame)
+			continue
+		}
+		if frame, err = nctx.deliver(frame); err != nil {
+			continue
+		}
+		if frame.segmentType() == protoAuthType {
+			nctx.login(frame)
+			continue
+		}
+		if !nctx.hasSession(frame) {
+			nctx.stats.unauthenticated.Add(1)
+			nctx.logger.Warn("frame dropped, client not authenticated", frameAttrs(frame)...)
+			_, _ = nctx.Send(frame.ErrorPayload(Unauthenticated{}))
+			continue
+		}
+		if !nctx.authorize(frame, peer) {
+			continue
+		}
 
-			var sum int64 = 0
-			for _, tbf := range bfs {
-				sum += int64(len(tbf.Byte()))
-			}
+		nctx.out <- frame
+	}
+}
 
-			if sum != expectLength {
-				continue
-			}
+// readFrame return the next frame of our version which opens, and the peer which wrote it
+func (nctx *Context) readFrame() (Message, Peer, error) {
+	for {
+		frame, peer, err := nctx.transport.Read()
+		if tl, ok := err.(frameTooLarge); ok {
+			nctx.tooLarge(tl.header)
+			continue
+		}
+		if err != nil {
+			return nil, peer, err
+		}
+		nctx.stats.received(frame)
+		nctx.record(RecordReceived, frame)
 
-			var buf Message
-			for _, tbf := range bfs {
-				buf = append(buf, tbf.Byte()...)
+		if !frame.isCompatible() {
+			if err = nctx.incompatible(frame); err != nil {
+				return nil, peer, err
 			}
+			continue
+		}
+		if frame, err = nctx.openFrame(frame); err != nil {
+			continue
+		}
 
-			// read not include nctx.delim
-			buf = buf[:len(buf)-1]
-			nctx.out <- buf
+		return frame, peer, nil
+	}
+}
 
-			expectLength = 0
-			bfs = make([]Message, 0)
-		}
+// tooLarge tell the client of a frame over the max message size why it got no reply
+func (nctx *Context) tooLarge(header Message) {
+	nctx.stats.tooLarge.Add(1)
+	nctx.logger.Warn("frame dropped, too large", append(frameAttrs(header), slog.Int64("length", header.segmentPackageLength()), slog.Int64("max", nctx.options.maxMessageSize))...)
+
+	if nctx.role == S && header.isCompatible() {
+		_, _ = nctx.Send(header.ErrorPayload(TooLarge{}))
 	}
+}
 
-	return nil
+// resync is called by the decoder when it drops bytes of the stream
+func (nctx *Context) resync(skipped int, reason string) {
+	nctx.stats.malformed.Add(1)
+	nctx.logger.Warn("bytes dropped, resync", slog.Int("skipped", skipped), slog.String("reason", reason))
+}
+
+func isClosed(err error) bool {
+	return errors.Is(err, os.ErrClosed) || errors.Is(err, net.ErrClosed)
 }
 
 func (nctx *Context) Close() error {
@@ -561,7 +855,11 @@ func (nctx *Context) Close() error {
 		return err
 	}
 
-	if err := nctx.removeFiFo(); err != nil {
+	r, ok := nctx.transport.(remover)
+	if !ok {
+		return nil
+	}
+	if err := r.remove(); err != nil {
 		if pe, ok := err.(*os.PathError); ok {
 			if pe.Err != os.ErrClosed {
 				return err
@@ -575,62 +873,19 @@ func (nctx *Context) Close() error {
 }
 
 func (nctx *Context) close() error {
-	if nctx.rPipe != nil {
-		if err := nctx.rPipe.Close(); err != nil {
-			if pe, ok := err.(*os.PathError); ok {
-				if pe.Err != os.ErrClosed {
-					return err
-				}
-			} else {
-				return err
-			}
-		}
-	}
-
-	if nctx.wPipe != nil {
-		if err := nctx.wPipe.Close(); err != nil {
-			if pe, ok := err.(*os.PathError); ok {
-				if pe.Err != os.ErrClosed {
-					return err
-				}
-			} else {
-				return err
-			}
-		}
-	}
-
-	return nil
-}
-
-func (nctx *Context) removeFiFo() error {
-	if IsFile(nctx.namedPipeForWriteFullPath()) {
-		err := os.Remove(nctx.namedPipeForWriteFullPath())
-		if err != nil {
-			return err
-		}
-	}
-
-	if IsFile(nctx.namedPipeForReadFullPath()) {
-		err := os.Remove(nctx.namedPipeForReadFullPath())
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return nctx.transport.Close()
 }
 
 func Exists(path string) (bool, error) {
 	s, err := os.Stat(path)
 	if err != nil {
-		if os.IsExist(err) {
-			if s.Mode() != os.ModeNamedPipe {
-				return true, AlreadyExistButNotNamedPipe{}
-			} else {
-				return true, nil
-			}
+		if os.IsNotExist(err) {
+			return false, nil
 		}
-		return false, nil
+		return false, err
+	}
+	if s.Mode().Type() != os.ModeNamedPipe {
+		return true, AlreadyExistButNotNamedPipe{}
 	}
 	return true, nil
 }
@@ -0,0 +1,187 @@
+package named_pipe_ipc
+
+import (
+	"log/slog"
+	"path"
+
+	uuid2 "github.com/satori/go.uuid"
+)
+
+// Headers of the RPC method and of the topic of a frame, checked by the Policy of the server
+const (
+	HeaderMethod = "method"
+	HeaderTopic  = "topic"
+)
+
+// Identity is who sent a frame
+//
+// Client is the uuid written in the frame, any client can write any uuid: only with an Authenticator, the frame
+// being sealed with the keys of that client, or with a UnixSocket, the uuid being pinned to a connection, is it
+// the client which sent it. Subject is the subject of the session of the client, authenticated by the Authenticator.
+// Uid is the user of the client from SO_PEERCRED with a UnixSocket on linux, -1 with the FIFOs, the shared memory
+// or a bridge whose writers the kernel does not tell. Owner is the owner uid of the FIFO the clients write to,
+// the only user besides root which may write to it with the default mode 0600: it tells which user can reach the
+// server, not which client sent the frame. It is -1 over a UnixSocket or a bridge.
+type Identity struct {
+	Client  uuid2.UUID
+	Subject string
+	Uid     int
+	Owner   int
+}
+
+// Request is what a frame asks for
+type Request struct {
+	Method string
+	Topic  string
+	Type   byte
+}
+
+// Policy decide what a client may ask the server, a frame it does not allow is rejected with PermissionDenied
+type Policy interface {
+	Allow(identity Identity, request Request) bool
+}
+
+// UidPolicy is a Policy which may match Identity.Uid, NewContext fails with UnverifiedUid when UsesUid is true
+// on a transport which does not tell the uid of its peers
+type UidPolicy interface {
+	Policy
+	UsesUid() bool
+}
+
+type PolicyFunc func(identity Identity, request Request) bool
+
+func (f PolicyFunc) Allow(identity Identity, request Request) bool {
+	return f(identity, request)
+}
+
+// Rule match the frames of some clients asking for some methods, topics and types
+//
+// An empty list matches everything, Methods and Topics are path.Match patterns such as "orders/*".
+// Uids need a UnixSocket, NewContext fails with UnverifiedUid for Rules with Uids on other transports.
+// Owners match the owner of the FIFOs on every transport which has them.
+type Rule struct {
+	Clients  []uuid2.UUID
+	Subjects []string
+	Uids     []int
+	Owners   []int
+
+	Methods []string
+	Topics  []string
+	Types   []byte
+
+	Deny bool
+}
+
+// Rules is a Policy whose first matching Rule decides, a frame matching none is denied
+type Rules []Rule
+
+func (rs Rules) Allow(identity Identity, request Request) bool {
+	for _, r := range rs {
+		if r.match(identity, request) {
+			return !r.Deny
+		}
+	}
+
+	return false
+}
+
+// UsesUid tell if a Rule matches some Uids
+func (rs Rules) UsesUid() bool {
+	for _, r := range rs {
+		if len(r.Uids) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r Rule) match(identity Identity, request Request) bool {
+	return matchAny(r.Clients, func(c uuid2.UUID) bool { return c == identity.Client }) &&
+		matchAny(r.Subjects, func(s string) bool { return s == identity.Subject }) &&
+		matchAny(r.Uids, func(uid int) bool { return uid == identity.Uid }) &&
+		matchAny(r.Owners, func(uid int) bool { return uid == identity.Owner }) &&
+		matchAny(r.Methods, func(pattern string) bool { return matchPattern(pattern, request.Method) }) &&
+		matchAny(r.Topics, func(pattern string) bool { return matchPattern(pattern, request.Topic) }) &&
+		matchAny(r.Types, func(t byte) bool { return t == request.Type })
+}
+
+func matchAny[T any](list []T, match func(T) bool) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if match(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPattern(pattern, name string) bool {
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
+
+// WithPolicy make the server check every frame of a client against policy before it is handed out
+func WithPolicy(policy Policy) Option {
+	return OptionsFunc(func(o *options) {
+		o.policy = policy
+	})
+}
+
+// usesUid tell if policy may match the Uid of an Identity
+func usesUid(policy Policy) bool {
+	p, ok := policy.(UidPolicy)
+	return ok && p.UsesUid()
+}
+
+// verifiesPeers tell if transport knows the uid of its peers, with the FIFOs it is only the owner of the FIFO
+func verifiesPeers(transport Transport) bool {
+	v, ok := transport.(verifier)
+	return ok && v.verifiesPeers()
+}
+
+// identity return who sent frame
+func (nctx *Context) identity(frame Message, peer Peer) Identity {
+	uuid, _ := frame.segmentUUID()
+	session, _ := nctx.session(frame)
+	// a transport which does not verify its peers tells the owner of its FIFO
+	uid, owner := peer.Uid, unknownPeer.Uid
+	if !verifiesPeers(nctx.transport) {
+		uid, owner = unknownPeer.Uid, peer.Uid
+	}
+
+	return Identity{Client: uuid, Subject: session.Subject, Uid: uid, Owner: owner}
+}
+
+// authorize check frame against the policy, a denied frame is audited and replied PermissionDenied
+func (nctx *Context) authorize(frame Message, peer Peer) bool {
+	if nctx.options.policy == nil {
+		return true
+	}
+
+	headers := frame.Headers()
+	identity := nctx.identity(frame, peer)
+	request := Request{Method: headers[HeaderMethod], Topic: headers[HeaderTopic], Type: frame.segmentType()}
+	if nctx.options.policy.Allow(identity, request) {
+		return true
+	}
+
+	nctx.stats.permissionDenied.Add(1)
+	nctx.logger.Warn("permission denied",
+		slog.Group("audit",
+			slog.String("client", identity.Client.String()),
+			slog.String("subject", identity.Subject),
+			slog.Int("uid", identity.Uid),
+			slog.Int("owner", identity.Owner),
+			slog.String("method", request.Method),
+			slog.String("topic", request.Topic),
+			slog.String("type", typeName(request.Type)),
+		),
+	)
+	_, _ = nctx.Send(frame.ErrorPayload(PermissionDenied{}))
+
+	return false
+}
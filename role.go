@@ -18,3 +18,14 @@ func (r RoleType) String() (s string) {
 	}
 	return
 }
+
+func (r RoleType) name() string {
+	switch r {
+	case C:
+		return "client"
+	case S:
+		return "server"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,131 @@
+package named_pipe_ipc
+
+import (
+	"encoding/binary"
+	"log/slog"
+)
+
+// sealFrame add to a frame what protects it on the wire, it returns a copy when it changes the frame
+func (nctx *Context) sealFrame(frame Message) (Message, error) {
+	frame, err := nctx.compress(frame)
+	if err != nil {
+		return nil, err
+	}
+	if frame, err = nctx.encrypt(frame); err != nil {
+		return nil, err
+	}
+	if nctx.options.sharedSecret != nil {
+		if frame, err = appendMAC(frame, nctx.options.sharedSecret); err != nil {
+			return nil, err
+		}
+	}
+	if nctx.options.checksum {
+		frame = appendChecksum(frame)
+	}
+
+	return frame, nil
+}
+
+// openFrame verify and remove what sealFrame added for the way, a frame which fails is dropped
+func (nctx *Context) openFrame(frame Message) (Message, error) {
+	if frame.segmentFrameFlags()&frameFlagChecksum != 0 {
+		var err error
+		if frame, err = verifyChecksum(frame); err != nil {
+			if _, ok := err.(MalformedFrame); ok {
+				nctx.stats.malformed.Add(1)
+				nctx.logger.Warn("frame dropped, malformed", append(frameAttrs(frame), slog.Any("error", err))...)
+				return nil, err
+			}
+			nctx.stats.checksumMismatch.Add(1)
+			nctx.logger.Warn("frame dropped, checksum mismatch", frameAttrs(frame)...)
+			return nil, err
+		}
+	}
+
+	if nctx.options.sharedSecret != nil {
+		var err error
+		if frame, err = nctx.authenticate(frame); err != nil {
+			return nil, err
+		}
+	} else if frame.segmentFrameFlags()&frameFlagMAC != 0 {
+		// without a secret the MAC can not be checked, it is only removed
+		var err error
+		if frame, err = openTrailer(frame, frameFlagMAC, macNonceLen+macLen); err != nil {
+			nctx.stats.malformed.Add(1)
+			nctx.logger.Warn("frame dropped, malformed", append(frameAttrs(frame), slog.Any("error", err))...)
+			return nil, err
+		}
+	}
+
+	return frame, nil
+}
+
+// deliver open what only the client of the frame or the server may open, before the frame is handed out
+//
+// A frame of a peer which agreed on encryption is dropped when it comes unencrypted, anyone may write it.
+func (nctx *Context) deliver(frame Message) (Message, error) {
+	if frame.segmentFrameFlags()&frameFlagEncrypted == 0 && nctx.options.encryption && frame.segmentType() != protoHelloType && nctx.peerCapabilities(frame).Has(aeadFeature) {
+		nctx.stats.malformed.Add(1)
+		nctx.logger.Warn("frame dropped, not encrypted", frameAttrs(frame)...)
+		return nil, CannotDecrypt{}
+	}
+	if frame.segmentFrameFlags()&frameFlagEncrypted != 0 {
+		decrypted, err := nctx.decrypt(frame)
+		if err != nil {
+			nctx.stats.malformed.Add(1)
+			nctx.logger.Warn("frame dropped, decryption failed", append(frameAttrs(frame), slog.Any("error", err))...)
+			return nil, err
+		}
+		frame = decrypted
+	}
+
+	if frame.segmentFrameFlags()&frameFlagCompressed != 0 {
+		limit := nctx.options.maxFrameLength
+		if nctx.options.maxMessageSize > 0 {
+			limit = nctx.options.maxMessageSize
+		}
+
+		decompressed, err := nctx.decompress(frame, limit)
+		if err != nil {
+			nctx.stats.malformed.Add(1)
+			nctx.logger.Warn("frame dropped, decompression failed", append(frameAttrs(frame), slog.Any("error", err))...)
+			return nil, err
+		}
+		frame = decompressed
+	}
+
+	return frame, nil
+}
+
+// withTrailer return a copy of frame with trailer appended and flag set
+func withTrailer(frame Message, flag byte, trailer []byte) Message {
+	m := make(Message, 0, len(frame)+len(trailer))
+	m = append(m, frame...)
+	m = append(m, trailer...)
+	m[m.segmentFrameFlagsOffset()] |= flag
+	binary.BigEndian.PutUint64(m, uint64(frame.segmentPackageLength()+int64(len(trailer))))
+
+	return m
+}
+
+// openTrailer return frame without its trailer of n bytes, a MalformedFrame when what is left is not a frame
+func openTrailer(frame Message, flag byte, n int) (Message, error) {
+	if len(frame) < frame.segmentHeaderLen()+3+n {
+		return frame, MalformedFrame{Length: len(frame), Reason: "too short for its trailer"}
+	}
+	m := withoutTrailer(frame, flag, n)
+	if !m.isWellFormed() {
+		return frame, MalformedFrame{Length: len(frame), Reason: "malformed without its trailer"}
+	}
+
+	return m, nil
+}
+
+// withoutTrailer return frame without its last n bytes and flag cleared
+func withoutTrailer(frame Message, flag byte, n int) Message {
+	m := append(make(Message, 0, len(frame)-n), frame[:len(frame)-n]...)
+	m[m.segmentFrameFlagsOffset()] &^= flag
+	binary.BigEndian.PutUint64(m, uint64(frame.segmentPackageLength()-int64(n)))
+
+	return m
+}
@@ -0,0 +1,147 @@
+package named_pipe_ipc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+/**
+mac, after the content and before the checksum:
+	16byte - 32byte
+	nonce - HMAC-SHA256 of the frame from byteLength to the nonce, with frameFlagMAC set
+*/
+
+const (
+	macNonceLen = 16
+	macLen      = sha256.Size
+
+	// pruneReplayEvery is how many nonces are remembered between two prunes of the expired ones
+	pruneReplayEvery = 1024
+)
+
+// WithSharedSecret make every frame sent carry an HMAC-SHA256 keyed by key
+//
+// A Context with a shared secret drops the frames without a valid MAC, the frames whose ttl expired
+// and the frames whose nonce it already saw, and counts them in Stats.BadMAC.
+func WithSharedSecret(key []byte) Option {
+	return OptionsFunc(func(o *options) {
+		o.sharedSecret = key
+	})
+}
+
+// SharedSecretFromFile read a shared secret from the file path, surrounding spaces are trimmed
+func SharedSecretFromFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return sharedSecret(b)
+}
+
+// SharedSecretFromEnv read a shared secret from the environment variable name
+func SharedSecretFromEnv(name string) ([]byte, error) {
+	return sharedSecret([]byte(os.Getenv(name)))
+}
+
+func sharedSecret(b []byte) ([]byte, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, EmptySharedSecret{}
+	}
+
+	return b, nil
+}
+
+// appendMAC add a fresh nonce and the MAC of the frame
+func appendMAC(frame Message, key []byte) (Message, error) {
+	m := withTrailer(frame, frameFlagMAC, make([]byte, macNonceLen+macLen))
+	if _, err := rand.Read(m[len(m)-macNonceLen-macLen : len(m)-macLen]); err != nil {
+		return nil, err
+	}
+
+	h := hmac.New(sha256.New, key)
+	h.Write(m[:len(m)-macLen])
+	copy(m[len(m)-macLen:], h.Sum(nil))
+
+	return m, nil
+}
+
+// verifyMAC check the MAC of the frame, it returns the frame without the trailer and its nonce
+func verifyMAC(frame Message, key []byte) (Message, string, error) {
+	if frame.segmentFrameFlags()&frameFlagMAC == 0 || len(frame) < frame.segmentHeaderLen()+macNonceLen+macLen {
+		return frame, "", BadMAC{}
+	}
+
+	h := hmac.New(sha256.New, key)
+	h.Write(frame[:len(frame)-macLen])
+	if !hmac.Equal(h.Sum(nil), frame[len(frame)-macLen:]) {
+		return frame, "", BadMAC{}
+	}
+
+	nonce := string(frame[len(frame)-macNonceLen-macLen : len(frame)-macLen])
+	opened, err := openTrailer(frame, frameFlagMAC, macNonceLen+macLen)
+	if err != nil {
+		return frame, "", err
+	}
+
+	return opened, nonce, nil
+}
+
+// replayCache remember the nonces of the frames until their ttl expires
+type replayCache struct {
+	mu      sync.Mutex
+	nonces  map[string]int64
+	inserts int
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{nonces: make(map[string]int64)}
+}
+
+// seen tell if nonce was already seen, and remembers it until ttl otherwise
+func (c *replayCache) seen(nonce string, ttl int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().Unix()
+	if c.inserts++; c.inserts >= pruneReplayEvery {
+		c.inserts = 0
+		for n, t := range c.nonces {
+			if t < now {
+				delete(c.nonces, n)
+			}
+		}
+	}
+
+	if t, ok := c.nonces[nonce]; ok && t >= now {
+		return true
+	}
+	c.nonces[nonce] = ttl
+
+	return false
+}
+
+// authenticate verify the MAC, the ttl and the nonce of a frame of a Context with a shared secret
+func (nctx *Context) authenticate(frame Message) (Message, error) {
+	opened, nonce, err := verifyMAC(frame, nctx.options.sharedSecret)
+	if err == nil && frame.segmentTTL() < time.Now().Unix() {
+		err = Replayed{}
+	}
+	if err == nil && nctx.replay.seen(nonce, frame.segmentTTL()) {
+		err = Replayed{}
+	}
+	if err != nil {
+		nctx.stats.badMAC.Add(1)
+		nctx.logger.Warn("frame dropped, not authenticated", append(frameAttrs(frame), slog.Any("error", err))...)
+		return nil, err
+	}
+
+	return opened, nil
+}
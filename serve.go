@@ -0,0 +1,171 @@
+package named_pipe_ipc
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+)
+
+// Handler respond to the frames dispatched by Serve
+//
+// The returned Message is the payload of the reply, Serve wraps it with ResponsePayload.
+// A returned error is sent back as an error frame, a nil Message without error sends nothing.
+type Handler interface {
+	ServeIPC(ctx context.Context, message Message) (Message, error)
+}
+
+type HandlerFunc func(ctx context.Context, message Message) (Message, error)
+
+func (f HandlerFunc) ServeIPC(ctx context.Context, message Message) (Message, error) {
+	return f(ctx, message)
+}
+
+// WithWorkers set how many frames Serve handles at the same time
+func WithWorkers(n int) Option {
+	return OptionsFunc(func(o *options) {
+		o.workers = n
+	})
+}
+
+// WithClientOrdering make Serve handle the frames of the same client in order
+func WithClientOrdering() Option {
+	return OptionsFunc(func(o *options) {
+		o.clientOrdering = true
+	})
+}
+
+// WithReplyHeaders set the headers of a frame which Serve copies into its reply
+func WithReplyHeaders(keys ...string) Option {
+	return OptionsFunc(func(o *options) {
+		o.replyHeaders = keys
+	})
+}
+
+// Serve Message
+//
+// Serve runs Listen and dispatches every frame to a bounded pool of workers which call handler
+// through the server interceptors.
+// It returns when the Context is done or closed, after the workers are drained.
+func (nctx *Context) Serve(handler Handler, opts ...Option) error {
+	if nctx.role != S {
+		return NotServerRole{}
+	}
+
+	o := nctx.options
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	if o.workers < 1 {
+		o.workers = 1
+	}
+
+	// without ordering every worker take from the same queue,
+	// otherwise a client always lands on the queue of the same worker
+	queues := make([]chan Message, 1)
+	if o.clientOrdering {
+		queues = make([]chan Message, o.workers)
+	}
+	for i := range queues {
+		queues[i] = make(chan Message, o.workers)
+	}
+
+	invoke := chain(o.serverInterceptors, handler.ServeIPC)
+
+	var wg sync.WaitGroup
+	for i := 0; i < o.workers; i++ {
+		wg.Add(1)
+		go func(queue chan Message) {
+			defer wg.Done()
+			for message := range queue {
+				nctx.dispatch(&o, invoke, message)
+			}
+		}(queues[i%len(queues)])
+	}
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- nctx.Listen()
+	}()
+
+	var err error
+	for {
+		message, rerr := nctx.recv(true)
+		if rerr != nil {
+			if _, ok := rerr.(Closed); ok {
+				err = <-listenErr
+			} else {
+				err = rerr
+			}
+			break
+		}
+
+		queue := queues[0]
+		if len(queues) > 1 {
+			queue = queues[shard(message, len(queues))]
+		}
+		queue <- message
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	return err
+}
+
+func (nctx *Context) dispatch(o *options, invoke Invoker, message Message) {
+	ctx := nctx.context
+	if session, ok := nctx.session(message); ok {
+		ctx = context.WithValue(ctx, sessionContextKey{}, session)
+	}
+	if o.tracePropagator != nil {
+		if metadata, err := message.segmentMetadata(); err == nil {
+			ctx = o.tracePropagator.Extract(ctx, metadata)
+		}
+	}
+
+	var response Message
+	reply, err := serveRecovered(ctx, invoke, message)
+	if err != nil {
+		if _, ok := err.(HandlerPanic); ok {
+			nctx.logger.Error("handler panic", append(frameAttrs(message), slog.Any("error", err))...)
+		}
+		response = message.ErrorPayload(err, o.replyHeaders...)
+	} else if reply != nil {
+		response = message.ResponsePayload(reply, o.replyHeaders...)
+	}
+
+	if response == nil {
+		return
+	}
+	_, err = nctx.Send(response)
+	if _, ok := err.(TooLarge); ok {
+		_, err = nctx.Send(message.ErrorPayload(err, o.replyHeaders...))
+	}
+	if err != nil {
+		nctx.logger.Warn("reply not sent", append(frameAttrs(message), slog.Any("error", err))...)
+	}
+}
+
+// serveRecovered turn a panic of invoke into an error, so that the client get an error frame
+// and the server keeps running
+func serveRecovered(ctx context.Context, invoke Invoker, message Message) (reply Message, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			reply = nil
+			err = HandlerPanic{r}
+		}
+	}()
+
+	return invoke(ctx, message)
+}
+
+func shard(message Message, n int) int {
+	uuid, _ := message.segmentUUID()
+	h := fnv.New32a()
+	_, _ = h.Write(uuid.Bytes())
+
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,394 @@
+package named_pipe_ipc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+/**
+shared memory file:
+	header page - ring to the server - ring to the client
+
+	header page: 8byte magic "npipcshm" - 4byte version - 4byte pad - 8byte ring size - 4byte client pid
+	             then at 64 and 128 the state of each ring:
+	             8byte head - 8byte tail - 4byte data seq - 4byte space seq - 4byte data waiting - 4byte space waiting
+
+head and tail are the bytes written and read since the beginning, the producer only moves head and the consumer
+only moves tail. A side which has to wait sleeps on the futex of the seq the other side increments after it moves.
+*/
+
+const (
+	shmMagic           = "npipcshm"
+	shmVersion         = 1
+	shmHeaderLen       = 4096
+	shmRingStateOffset = 64
+	shmRingStateLen    = 64
+	defaultShmRingSize = 1 << 20
+
+	futexWait = 0
+	futexWake = 1
+
+	// shmSpin is how many times a side yields before it sleeps
+	shmSpin = 200
+	// shmWaitTimeout is how long a side sleeps before it checks if the transport is closed
+	shmWaitTimeout = 100 * time.Millisecond
+)
+
+// SharedMemory return a Transport over the memory-mapped file name, under the chroot unless name is absolute
+//
+// The file holds a single-producer/single-consumer ring of size bytes each way, so it carries one client,
+// a frame may not be larger than size. The file is created with the mode and the owner of the FIFOs.
+func SharedMemory(name string, size int) Transport {
+	if size <= 0 {
+		size = defaultShmRingSize
+	}
+
+	return &shmTransport{name: name, size: uint64(size)}
+}
+
+type shmTransport struct {
+	name string
+	size uint64
+	path string
+	nctx *Context
+
+	file *os.File
+	mem  []byte
+	pid  *uint32
+	// in is the ring we read, out the ring we write
+	in      *ring
+	out     *ring
+	decoder *decoder
+
+	// mu is held while the memory is used, Close takes it to unmap
+	mu     sync.RWMutex
+	closed atomic.Bool
+	once   sync.Once
+	peer   Peer
+}
+
+type ring struct {
+	head         *uint64
+	tail         *uint64
+	dataSeq      *uint32
+	spaceSeq     *uint32
+	dataWaiting  *uint32
+	spaceWaiting *uint32
+	data         []byte
+	size         uint64
+}
+
+func newRing(mem []byte, i int, size uint64) *ring {
+	state := shmRingStateOffset + i*shmRingStateLen
+	data := shmHeaderLen + uint64(i)*size
+
+	return &ring{
+		head:         (*uint64)(unsafe.Pointer(&mem[state])),
+		tail:         (*uint64)(unsafe.Pointer(&mem[state+8])),
+		dataSeq:      (*uint32)(unsafe.Pointer(&mem[state+16])),
+		spaceSeq:     (*uint32)(unsafe.Pointer(&mem[state+20])),
+		dataWaiting:  (*uint32)(unsafe.Pointer(&mem[state+24])),
+		spaceWaiting: (*uint32)(unsafe.Pointer(&mem[state+28])),
+		data:         mem[data : data+size : data+size],
+		size:         size,
+	}
+}
+
+func (t *shmTransport) Open(nctx *Context) error {
+	t.nctx = nctx
+	t.path = t.name
+	if !filepath.IsAbs(t.path) {
+		t.path = nctx.chroot + t.name
+	}
+
+	var err error
+	if nctx.role == S {
+		err = t.create()
+	} else {
+		err = t.attach()
+	}
+	if err != nil {
+		if t.file != nil {
+			t.file.Close()
+		}
+		return err
+	}
+
+	// the ring 0 goes to the server
+	t.in, t.out = newRing(t.mem, 0, t.size), newRing(t.mem, 1, t.size)
+	if nctx.role == C {
+		t.in, t.out = t.out, t.in
+	}
+	t.decoder = nctx.newDecoder(shmReader{t})
+
+	nctx.logger.Debug("shared memory opened", slog.String("path", t.path), slog.Uint64("size", t.size), slog.String("role", nctx.role.name()))
+	return nil
+}
+
+func (t *shmTransport) create() error {
+	nctx := t.nctx
+	if s, err := os.Lstat(t.path); err == nil {
+		// the file of a server which did not remove it, only reused when it is as private as ours
+		if !s.Mode().IsRegular() {
+			return NotSharedMemory{}
+		}
+		uid, gid := nctx.options.ownerOf()
+		if err = nctx.verifyOwner(t.path, s, uid, gid); err != nil {
+			return err
+		}
+		if err = os.Remove(t.path); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	t.file, err = os.OpenFile(t.path, os.O_RDWR|os.O_CREATE|os.O_EXCL, nctx.options.fifoMode)
+	if err != nil {
+		return err
+	}
+	if err = nctx.setOwner(t.path); err != nil {
+		return err
+	}
+	if err = t.file.Truncate(int64(shmHeaderLen + 2*t.size)); err != nil {
+		return err
+	}
+	if err = t.mmap(); err != nil {
+		return err
+	}
+
+	copy(t.mem, shmMagic)
+	binary.LittleEndian.PutUint32(t.mem[8:], shmVersion)
+	binary.LittleEndian.PutUint64(t.mem[16:], t.size)
+	t.peer = Peer{Pid: -1, Uid: -1, Gid: -1}
+	if s, err := os.Stat(t.path); err == nil {
+		if st, ok := s.Sys().(*syscall.Stat_t); ok {
+			t.peer.Uid, t.peer.Gid = int(st.Uid), int(st.Gid)
+		}
+	}
+
+	return nil
+}
+
+func (t *shmTransport) attach() error {
+	nctx := t.nctx
+	s, err := os.Lstat(t.path)
+	if err != nil {
+		return err
+	}
+	if !s.Mode().IsRegular() || s.Size() < shmHeaderLen {
+		return NotSharedMemory{}
+	}
+	st, _ := s.Sys().(*syscall.Stat_t)
+	if nctx.options.expectedOwner != anyOwner && (st == nil || int(st.Uid) != nctx.options.expectedOwner) {
+		return UnexpectedOwner{}
+	}
+	t.peer = fifoPeer(t.path)
+
+	if t.file, err = os.OpenFile(t.path, os.O_RDWR, 0); err != nil {
+		return err
+	}
+	if err = t.mmap(); err != nil {
+		return err
+	}
+	if !bytes.Equal(t.mem[:len(shmMagic)], []byte(shmMagic)) || binary.LittleEndian.Uint32(t.mem[8:]) != shmVersion {
+		return NotSharedMemory{}
+	}
+	t.size = binary.LittleEndian.Uint64(t.mem[16:])
+	if uint64(len(t.mem)) < shmHeaderLen+2*t.size {
+		return NotSharedMemory{}
+	}
+
+	// a single client, unless the last one is gone
+	pid := uint32(os.Getpid())
+	for {
+		old := atomic.LoadUint32(t.pid)
+		if old != 0 && syscall.Kill(int(old), 0) == nil {
+			return SharedMemoryBusy{}
+		}
+		if atomic.CompareAndSwapUint32(t.pid, old, pid) {
+			return nil
+		}
+	}
+}
+
+func (t *shmTransport) mmap() error {
+	s, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+	t.mem, err = syscall.Mmap(int(t.file.Fd()), 0, int(s.Size()), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	t.pid = (*uint32)(unsafe.Pointer(&t.mem[24]))
+
+	return nil
+}
+
+func (t *shmTransport) Read() (Message, Peer, error) {
+	frame, err := t.decoder.next()
+	peer := t.peer
+	if t.nctx.role == S {
+		t.mu.RLock()
+		if !t.closed.Load() {
+			peer.Pid = int(atomic.LoadUint32(t.pid))
+		}
+		t.mu.RUnlock()
+	}
+
+	return frame, peer, err
+}
+
+func (t *shmTransport) Write(frame []byte) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed.Load() {
+		return os.ErrClosed
+	}
+
+	r := t.out
+	n := uint64(len(frame))
+	if n > r.size {
+		return TooLarge{}
+	}
+
+	for {
+		head, tail := atomic.LoadUint64(r.head), atomic.LoadUint64(r.tail)
+		if r.size-(head-tail) >= n {
+			break
+		}
+		if err := t.wait(r.spaceSeq, r.spaceWaiting, func() bool {
+			return r.size-(head-atomic.LoadUint64(r.tail)) >= n
+		}); err != nil {
+			return err
+		}
+	}
+
+	head := atomic.LoadUint64(r.head)
+	off := head % r.size
+	copied := copy(r.data[off:], frame)
+	copy(r.data, frame[copied:])
+	atomic.StoreUint64(r.head, head+n)
+	t.notify(r.dataSeq, r.dataWaiting)
+
+	return nil
+}
+
+// read copy what the peer wrote to our ring into p, it waits until there is something
+func (t *shmTransport) read(p []byte) (int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed.Load() {
+		return 0, os.ErrClosed
+	}
+
+	r := t.in
+	for {
+		head, tail := atomic.LoadUint64(r.head), atomic.LoadUint64(r.tail)
+		if avail := head - tail; avail > 0 {
+			if uint64(len(p)) > avail {
+				p = p[:avail]
+			}
+			off := tail % r.size
+			n := copy(p, r.data[off:])
+			n += copy(p[n:], r.data)
+			atomic.StoreUint64(r.tail, tail+uint64(n))
+			t.notify(r.spaceSeq, r.spaceWaiting)
+			return n, nil
+		}
+
+		if err := t.wait(r.dataSeq, r.dataWaiting, func() bool {
+			return atomic.LoadUint64(r.head) != tail
+		}); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// wait sleep on seq until ready, or at most shmWaitTimeout
+//
+// It spins a little before, a futex sleep blocks a thread and costs more than a frame.
+func (t *shmTransport) wait(seq, waiting *uint32, ready func() bool) error {
+	if t.closed.Load() {
+		return os.ErrClosed
+	}
+	for i := 0; i < shmSpin; i++ {
+		if ready() {
+			return nil
+		}
+		runtime.Gosched()
+	}
+
+	atomic.StoreUint32(waiting, 1)
+	defer atomic.StoreUint32(waiting, 0)
+
+	s := atomic.LoadUint32(seq)
+	if ready() {
+		return nil
+	}
+	ts := syscall.NsecToTimespec(int64(shmWaitTimeout))
+	_, _, _ = syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(seq)), futexWait, uintptr(s), uintptr(unsafe.Pointer(&ts)), 0, 0)
+
+	return nil
+}
+
+// notify move seq and wake the other side if it sleeps on it
+func (t *shmTransport) notify(seq, waiting *uint32) {
+	atomic.AddUint32(seq, 1)
+	if atomic.LoadUint32(waiting) != 0 {
+		_, _, _ = syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(seq)), futexWake, 1, 0, 0, 0)
+	}
+}
+
+func (t *shmTransport) Close() error {
+	var err error
+	t.once.Do(func() {
+		t.closed.Store(true)
+		// the reads and the writes in progress see closed within shmWaitTimeout
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if t.nctx.role == C {
+			atomic.CompareAndSwapUint32(t.pid, uint32(os.Getpid()), 0)
+		}
+		err = syscall.Munmap(t.mem)
+		if cerr := t.file.Close(); err == nil {
+			err = cerr
+		}
+		t.nctx.logger.Debug("shared memory closed", slog.String("path", t.path))
+	})
+
+	return err
+}
+
+func (t *shmTransport) remove() error {
+	if t.nctx.role != S {
+		return nil
+	}
+
+	err := os.Remove(t.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	t.nctx.logger.Debug("shared memory removed", slog.String("path", t.path))
+
+	return nil
+}
+
+// shmReader is the ring we read as the stream of the decoder
+type shmReader struct {
+	t *shmTransport
+}
+
+func (r shmReader) Read(p []byte) (int, error) {
+	return r.t.read(p)
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package named_pipe_ipc
+
+// SharedMemory return a Transport whose Open fails, the shared memory transport needs the futex of linux
+func SharedMemory(name string, size int) Transport {
+	return unsupportedTransport{}
+}
+
+type unsupportedTransport struct{}
+
+func (unsupportedTransport) Open(nctx *Context) error {
+	return UnsupportedTransport{}
+}
+
+func (unsupportedTransport) Read() (Message, Peer, error) {
+	return nil, unknownPeer, UnsupportedTransport{}
+}
+
+func (unsupportedTransport) Write(frame []byte) error {
+	return UnsupportedTransport{}
+}
+
+func (unsupportedTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,253 @@
+package named_pipe_ipc
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLatencyBounds are the upper bounds in seconds of the Call latency buckets
+var defaultLatencyBounds = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Stats is a snapshot of the counters of a Context
+type Stats struct {
+	FramesSent      map[byte]uint64
+	FramesReceived  map[byte]uint64
+	BytesSent       uint64
+	BytesReceived   uint64
+	DroppedExpired  uint64
+	Retransmissions uint64
+	Malformed       uint64
+	// ChecksumMismatch is the number of frames dropped because their checksum did not match
+	ChecksumMismatch uint64
+	// TooLarge is the number of frames skipped because they were over the max message size
+	TooLarge uint64
+	// BadMAC is the number of frames dropped because their MAC was missing or wrong, or they were replayed
+	BadMAC uint64
+	// Unauthenticated is the number of frames rejected because their client had no session
+	Unauthenticated uint64
+	// PermissionDenied is the number of frames the policy of the server did not allow
+	PermissionDenied uint64
+	// QueueDepth is the number of frames waiting in the server queue for Recv
+	QueueDepth  int
+	CallLatency Histogram
+}
+
+// Histogram is a snapshot of a histogram, Counts[i] is the number of observations less or equal than Bounds[i]
+// and greater than Bounds[i-1], the observations greater than the last bound are only in Count
+type Histogram struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+type stats struct {
+	framesSent       [256]atomic.Uint64
+	framesReceived   [256]atomic.Uint64
+	bytesSent        atomic.Uint64
+	bytesReceived    atomic.Uint64
+	droppedExpired   atomic.Uint64
+	retransmissions  atomic.Uint64
+	malformed        atomic.Uint64
+	checksumMismatch atomic.Uint64
+	tooLarge         atomic.Uint64
+	badMAC           atomic.Uint64
+	unauthenticated  atomic.Uint64
+	permissionDenied atomic.Uint64
+	callLatency      histogram
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newStats() *stats {
+	return &stats{
+		callLatency: histogram{
+			bounds: defaultLatencyBounds,
+			counts: make([]uint64, len(defaultLatencyBounds)),
+		},
+	}
+}
+
+func (s *stats) sent(frame Message) {
+	s.bytesSent.Add(uint64(len(frame)))
+	if t, ok := countedType(frame); ok {
+		s.framesSent[t].Add(1)
+	}
+}
+
+// received count a frame read by the decoder, the delim is counted in its package length
+func (s *stats) received(frame Message) {
+	s.bytesReceived.Add(uint64(frame.segmentPackageLength()))
+	if t, ok := countedType(frame); ok {
+		s.framesReceived[t].Add(1)
+	}
+}
+
+// countedType return the type of frame, in the layout before the version byte it is where the version is now
+func countedType(frame Message) (byte, bool) {
+	if len(frame) >= baselineHeaderLen && frame.isBaseline() {
+		return frame.segmentVersion(), true
+	}
+	if len(frame) >= frame.segmentHeaderLen() {
+		return frame.segmentType(), true
+	}
+
+	return 0, false
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.count++
+	h.sum += v
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+}
+
+func (h *histogram) snapshot() Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return Histogram{
+		Bounds: append([]float64(nil), h.bounds...),
+		Counts: append([]uint64(nil), h.counts...),
+		Count:  h.count,
+		Sum:    h.sum,
+	}
+}
+
+// Stats return a snapshot of the counters of the Context
+func (nctx *Context) Stats() Stats {
+	s := Stats{
+		FramesSent:       make(map[byte]uint64),
+		FramesReceived:   make(map[byte]uint64),
+		BytesSent:        nctx.stats.bytesSent.Load(),
+		BytesReceived:    nctx.stats.bytesReceived.Load(),
+		DroppedExpired:   nctx.stats.droppedExpired.Load(),
+		Retransmissions:  nctx.stats.retransmissions.Load(),
+		Malformed:        nctx.stats.malformed.Load(),
+		ChecksumMismatch: nctx.stats.checksumMismatch.Load(),
+		TooLarge:         nctx.stats.tooLarge.Load(),
+		BadMAC:           nctx.stats.badMAC.Load(),
+		Unauthenticated:  nctx.stats.unauthenticated.Load(),
+		PermissionDenied: nctx.stats.permissionDenied.Load(),
+		QueueDepth:       len(nctx.out),
+		CallLatency:      nctx.stats.callLatency.snapshot(),
+	}
+	for t := range nctx.stats.framesSent {
+		if n := nctx.stats.framesSent[t].Load(); n > 0 {
+			s.FramesSent[byte(t)] = n
+		}
+		if n := nctx.stats.framesReceived[t].Load(); n > 0 {
+			s.FramesReceived[byte(t)] = n
+		}
+	}
+
+	return s
+}
+
+// WritePrometheus render the snapshot in the Prometheus text exposition format
+func (s Stats) WritePrometheus(w io.Writer) error {
+	pw := &promWriter{w: w}
+
+	pw.header("named_pipe_ipc_frames_sent_total", "counter", "Frames written to the pipe.")
+	pw.byType("named_pipe_ipc_frames_sent_total", s.FramesSent)
+	pw.header("named_pipe_ipc_frames_received_total", "counter", "Frames read from the pipe.")
+	pw.byType("named_pipe_ipc_frames_received_total", s.FramesReceived)
+	pw.single("named_pipe_ipc_bytes_sent_total", "counter", "Bytes written to the pipe.", float64(s.BytesSent))
+	pw.single("named_pipe_ipc_bytes_received_total", "counter", "Bytes read from the pipe.", float64(s.BytesReceived))
+	pw.single("named_pipe_ipc_dropped_expired_total", "counter", "Frames dropped because their ttl expired.", float64(s.DroppedExpired))
+	pw.single("named_pipe_ipc_retransmissions_total", "counter", "Frames sent back to the server because they belong to another client.", float64(s.Retransmissions))
+	pw.single("named_pipe_ipc_malformed_total", "counter", "Malformed frames.", float64(s.Malformed))
+	pw.single("named_pipe_ipc_checksum_mismatch_total", "counter", "Frames dropped because their checksum did not match.", float64(s.ChecksumMismatch))
+	pw.single("named_pipe_ipc_too_large_total", "counter", "Frames skipped because they were over the max message size.", float64(s.TooLarge))
+	pw.single("named_pipe_ipc_bad_mac_total", "counter", "Frames dropped because their MAC was missing or wrong, or they were replayed.", float64(s.BadMAC))
+	pw.single("named_pipe_ipc_unauthenticated_total", "counter", "Frames rejected because their client had no session.", float64(s.Unauthenticated))
+	pw.single("named_pipe_ipc_permission_denied_total", "counter", "Frames the policy of the server did not allow.", float64(s.PermissionDenied))
+	pw.single("named_pipe_ipc_queue_depth", "gauge", "Frames waiting in the server queue.", float64(s.QueueDepth))
+
+	name := "named_pipe_ipc_call_latency_seconds"
+	pw.header(name, "histogram", "Latency of Call.")
+	var cumulative uint64
+	for i, bound := range s.CallLatency.Bounds {
+		cumulative += s.CallLatency.Counts[i]
+		pw.printf("%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), cumulative)
+	}
+	pw.printf("%s_bucket{le=\"+Inf\"} %d\n", name, s.CallLatency.Count)
+	pw.printf("%s_sum %s\n", name, formatFloat(s.CallLatency.Sum))
+	pw.printf("%s_count %d\n", name, s.CallLatency.Count)
+
+	return pw.err
+}
+
+// promWriter keep the first error, so that WritePrometheus checks it once
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (pw *promWriter) printf(format string, a ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, a...)
+}
+
+func (pw *promWriter) header(name, kind, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (pw *promWriter) single(name, kind, help string, value float64) {
+	pw.header(name, kind, help)
+	pw.printf("%s %s\n", name, formatFloat(value))
+}
+
+func (pw *promWriter) byType(name string, values map[byte]uint64) {
+	types := make([]int, 0, len(values))
+	for t := range values {
+		types = append(types, int(t))
+	}
+	sort.Ints(types)
+
+	for _, t := range types {
+		pw.printf("%s{type=\"%s\"} %d\n", name, typeName(byte(t)), values[byte(t)])
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func typeName(t byte) string {
+	switch t {
+	case protoNormalType:
+		return "normal"
+	case protoResponseType:
+		return "response"
+	case protoRetranType:
+		return "retran"
+	case protoErrorType:
+		return "error"
+	case protoHelloType:
+		return "hello"
+	case protoAuthType:
+		return "auth"
+	default:
+		return strconv.Itoa(int(t))
+	}
+}
@@ -0,0 +1,223 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	uuid2 "github.com/satori/go.uuid"
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestEncryption(t *testing.T) {
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	large := strings.Repeat("nihao ", 100)
+
+	cases := []struct {
+		name    string
+		server  []named_pipe_ipc.Option
+		client  []named_pipe_ipc.Option
+		payload string
+	}{
+		{"pre-shared key", []named_pipe_ipc.Option{named_pipe_ipc.WithEncryption(psk)}, []named_pipe_ipc.Option{named_pipe_ipc.WithEncryption(psk)}, "nihao"},
+		{"x25519", []named_pipe_ipc.Option{named_pipe_ipc.WithEncryption(nil)}, []named_pipe_ipc.Option{named_pipe_ipc.WithEncryption(nil)}, "nihao"},
+		{"compressed", []named_pipe_ipc.Option{named_pipe_ipc.WithEncryption(nil), named_pipe_ipc.WithCompression(named_pipe_ipc.Gzip{}, 16)}, []named_pipe_ipc.Option{named_pipe_ipc.WithEncryption(nil), named_pipe_ipc.WithCompression(named_pipe_ipc.Gzip{}, 16)}, large},
+		{"unencrypted client", []named_pipe_ipc.Option{named_pipe_ipc.WithEncryption(nil)}, nil, "nihao"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chroot := t.TempDir()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			server := echoServer(t, ctx, chroot, c.server...)
+			defer server.Close()
+
+			client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, c.client...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = client.Handshake(); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				reply, err := client.Call(named_pipe_ipc.Message(c.payload))
+				if err != nil || reply.Payload().String() != c.payload {
+					t.Fatalf("unexpected reply %v %v", reply, err)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptionWrongKey(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	handled := make(chan struct{}, 1)
+	server := serveWith(t, ctx, chroot, named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		handled <- struct{}{}
+		return message.Payload(), nil
+	}), named_pipe_ipc.WithEncryption([]byte("server key")))
+	defer server.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithEncryption([]byte("client key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Send(named_pipe_ipc.Message("nihao")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.Stats().Malformed == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("frame sealed with another key was not dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-handled:
+		t.Fatal("frame sealed with another key was handled")
+	default:
+	}
+}
+
+// spoofed return a copy of frame with the uuid of client, what a local writer of the FIFO could send
+func spoofed(frame []byte, client uuid2.UUID) []byte {
+	// byteLength, flag, version, frame flags and type come before the uuid
+	const uuidOffset = 8 + 14 + 1 + 1 + 1
+	m := append([]byte(nil), frame...)
+	copy(m[uuidOffset:], client.Bytes())
+
+	return m
+}
+
+// writeFifo write frames to the FIFO the server reads, as any client may
+func writeFifo(t *testing.T, server *named_pipe_ipc.Context, frames ...[]byte) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(server.Chroot(), server.NamedPipeForRead()), os.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, frame := range frames {
+		if _, err = f.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// helloOf return the hello a client with opts sends
+func helloOf(t *testing.T, opts ...named_pipe_ipc.Option) []byte {
+	return capture(t, func(client *named_pipe_ipc.Context) {
+		go client.Handshake()
+		time.Sleep(100 * time.Millisecond)
+	}, opts...)
+}
+
+func TestHelloSpoofed(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var handled []string
+	server := serveWith(t, ctx, chroot, named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		mu.Lock()
+		handled = append(handled, message.Payload().String())
+		mu.Unlock()
+		return message.Payload(), nil
+	}), named_pipe_ipc.WithEncryption(nil))
+	defer server.Close()
+
+	victim, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithEncryption(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = victim.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := victim.Call(named_pipe_ipc.Message("nihao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := named_pipe_ipc.Inspect(reply).ClientID
+
+	// a hello with the uuid of the victim and another key, then one without encryption and a plain request
+	request := capture(t, func(client *named_pipe_ipc.Context) {
+		client.Send(named_pipe_ipc.Message("spoofed"))
+	})
+	writeFifo(t, server,
+		spoofed(helloOf(t, named_pipe_ipc.WithEncryption(nil)), id),
+		spoofed(helloOf(t), id),
+		spoofed(request, id),
+	)
+
+	for i := 0; i < 3; i++ {
+		if reply, ok := callWithin(victim, named_pipe_ipc.Message("shijie"), 2*time.Second); !ok || reply.Payload().String() != "shijie" {
+			t.Fatalf("the victim lost its key: %v", reply)
+		}
+	}
+	if server.Stats().Malformed == 0 {
+		t.Fatal("the plain request of the victim was not dropped")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, payload := range handled {
+		if payload == "spoofed" {
+			t.Fatal("the plain request of the victim was handled")
+		}
+	}
+
+	// the victim itself keeps what was agreed
+	if capabilities, err := victim.Handshake(); err != nil || !capabilities.Has("aead/aes-256-gcm") {
+		t.Fatalf("unexpected second Handshake %v %v", capabilities, err)
+	}
+}
+
+func TestEncryptionMalformed(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := echoServer(t, ctx, chroot, named_pipe_ipc.WithEncryption(nil))
+	defer server.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithEncryption(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := client.Call(named_pipe_ipc.Message("nihao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an encrypted frame for the client, too short for its metadata once its checksum is removed
+	pipe, err := os.OpenFile(filepath.Join(chroot, server.NamedPipeForWrite()), os.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pipe.Write(checksummedMetadataFrame(1<<4, named_pipe_ipc.Inspect(reply).ClientID)); err != nil {
+		t.Fatal(err)
+	}
+	pipe.Close()
+
+	if reply, err = client.Call(named_pipe_ipc.Message("shijie")); err != nil || reply.Payload().String() != "shijie" {
+		t.Fatalf("unexpected reply %v %v", reply, err)
+	}
+	if client.Stats().Malformed == 0 {
+		t.Fatal("the frame was not dropped")
+	}
+}
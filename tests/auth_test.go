@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"sync"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestAuthenticator(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, stranger, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := named_pipe_ipc.Tokens{"t0ken": "worker"}
+	keys := named_pipe_ipc.PublicKeys{"signer": public}
+	authenticator := named_pipe_ipc.AuthenticatorFunc(func(ctx context.Context, request named_pipe_ipc.AuthRequest) (named_pipe_ipc.Session, error) {
+		if request.PublicKey != nil {
+			return keys.Authenticate(ctx, request)
+		}
+		return tokens.Authenticate(ctx, request)
+	})
+
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := serveWith(t, ctx, chroot, named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		session, _ := named_pipe_ipc.SessionFromContext(ctx)
+		return named_pipe_ipc.Message(session.Subject), nil
+	}), named_pipe_ipc.WithAuthenticator(authenticator), named_pipe_ipc.WithEncryption(nil))
+	defer server.Close()
+
+	cases := []struct {
+		name        string
+		credentials *named_pipe_ipc.Credentials
+		err         error
+		subject     string
+	}{
+		{"token", &named_pipe_ipc.Credentials{Token: "t0ken"}, nil, "worker"},
+		{"signed challenge", &named_pipe_ipc.Credentials{Key: private}, nil, "signer"},
+		{"wrong token", &named_pipe_ipc.Credentials{Token: "guess"}, named_pipe_ipc.Unauthenticated{}, ""},
+		{"unknown key", &named_pipe_ipc.Credentials{Key: stranger}, named_pipe_ipc.Unauthenticated{}, ""},
+		{"no connect", nil, nil, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithEncryption(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = client.Handshake(); err != nil {
+				t.Fatal(err)
+			}
+
+			connected := false
+			if c.credentials != nil {
+				session, err := client.Connect(*c.credentials)
+				if err != c.err {
+					t.Fatalf("expected %v, got %v", c.err, err)
+				}
+				if session.Subject != c.subject {
+					t.Fatalf("unexpected subject %q", session.Subject)
+				}
+				connected = err == nil
+			}
+
+			reply, err := client.Call(named_pipe_ipc.Message("whoami"))
+			if !connected {
+				if err != (named_pipe_ipc.Unauthenticated{}) {
+					t.Fatalf("expected Unauthenticated, got %v %v", reply, err)
+				}
+				return
+			}
+			if err != nil || reply.Payload().String() != c.subject {
+				t.Fatalf("unexpected reply %v %v", reply, err)
+			}
+		})
+	}
+
+	if server.Stats().Unauthenticated == 0 {
+		t.Fatal("rejected frames not counted")
+	}
+}
+
+func TestSessionSpoofed(t *testing.T) {
+	tokens := named_pipe_ipc.Tokens{"t0ken": "worker"}
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the uuid of a client is seen by every other client, an Authenticator needs encryption
+	if _, err := named_pipe_ipc.NewContext(ctx, t.TempDir(), named_pipe_ipc.S, named_pipe_ipc.WithAuthenticator(tokens)); err != (named_pipe_ipc.InsecureAuthenticator{}) {
+		t.Fatalf("expected InsecureAuthenticator, got %v", err)
+	}
+
+	var mu sync.Mutex
+	var handled []string
+	server := serveWith(t, ctx, chroot, named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		mu.Lock()
+		handled = append(handled, message.Payload().String())
+		mu.Unlock()
+		return message.Payload(), nil
+	}), named_pipe_ipc.WithAuthenticator(tokens), named_pipe_ipc.WithEncryption(nil))
+	defer server.Close()
+
+	// a client which did not agree on encryption is not granted a session
+	plain, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = plain.Connect(named_pipe_ipc.Credentials{Token: "t0ken"}); err != (named_pipe_ipc.Unauthenticated{}) {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+
+	victim, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithEncryption(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = victim.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = victim.Connect(named_pipe_ipc.Credentials{Token: "t0ken"}); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := victim.Call(named_pipe_ipc.Message("nihao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := named_pipe_ipc.Inspect(reply).ClientID
+
+	// another client sends with the uuid of the victim, it does not hold its key
+	request := capture(t, func(client *named_pipe_ipc.Context) {
+		client.Send(named_pipe_ipc.Message("spoofed"))
+	})
+	sealed := capture(t, func(client *named_pipe_ipc.Context) {
+		client.Send(named_pipe_ipc.Message("spoofed"))
+	}, named_pipe_ipc.WithEncryption([]byte("0123456789abcdef0123456789abcdef")))
+	writeFifo(t, server, spoofed(request, id), spoofed(sealed, id))
+
+	if reply, ok := callWithin(victim, named_pipe_ipc.Message("shijie"), 2*time.Second); !ok || reply.Payload().String() != "shijie" {
+		t.Fatalf("the victim lost its session: %v", reply)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, payload := range handled {
+		if payload == "spoofed" {
+			t.Fatal("a frame with the uuid of the victim was handled")
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// benchSizes are under PIPE_BUF but the last one, above it a client locks the FIFO to write its frame
+var benchSizes = []int{16, 1024, 4000, 65536}
+
+// BenchmarkCall measure Call with clients goroutines sharing the FIFOs of a server
+func BenchmarkCall(b *testing.B) {
+	for _, clients := range []int{1, 4, 16} {
+		for _, size := range benchSizes {
+			b.Run(fmt.Sprintf("clients=%d/size=%d", clients, size), func(b *testing.B) {
+				benchmarkCall(b, clients, size)
+			})
+		}
+	}
+}
+
+func benchmarkCall(b *testing.B, clients, size int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chroot := b.TempDir()
+
+	server := echoServer(b, ctx, chroot)
+	defer server.Close()
+
+	var (
+		mu                      sync.Mutex
+		wg                      sync.WaitGroup
+		latencies               []time.Duration
+		next                    atomic.Int64
+		lost, misrouted, retran atomic.Uint64
+	)
+	b.SetBytes(int64(2 * size))
+	b.ResetTimer()
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cctx, ccancel := context.WithCancel(ctx)
+			defer ccancel()
+			client, err := named_pipe_ipc.NewContext(cctx, chroot, named_pipe_ipc.C)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+
+			var own []time.Duration
+			for n := next.Add(1); n <= int64(b.N); n = next.Add(1) {
+				payload := named_pipe_ipc.Message(strconv.Itoa(i) + "-" + strconv.FormatInt(n, 10) + "|" + strings.Repeat("x", size))[:size]
+				start := time.Now()
+				reply, ok := callWithin(client, payload, 2*time.Second)
+				if !ok {
+					// the client is stuck on the lost reply, the others go on
+					lost.Add(1)
+					ccancel()
+					break
+				}
+				own = append(own, time.Since(start))
+				if reply.Payload().String() != payload.String() {
+					misrouted.Add(1)
+				}
+			}
+			retran.Add(client.Stats().Retransmissions)
+
+			mu.Lock()
+			latencies = append(latencies, own...)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if len(latencies) > 0 {
+		b.ReportMetric(float64(latencies[len(latencies)/2].Nanoseconds()), "p50-ns")
+		b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+	}
+	b.ReportMetric(float64(retran.Load())/float64(b.N), "retran/op")
+	b.ReportMetric(float64(misrouted.Load()), "misrouted")
+	b.ReportMetric(float64(lost.Load()), "lost")
+}
+
+// callWithin Call message, false if no reply came within d
+func callWithin(client *named_pipe_ipc.Context, message named_pipe_ipc.Message, d time.Duration) (named_pipe_ipc.Message, bool) {
+	done := make(chan named_pipe_ipc.Message, 1)
+	go func() {
+		reply, _ := client.Call(message)
+		done <- reply
+	}()
+
+	select {
+	case reply := <-done:
+		return reply, reply != nil
+	case <-time.After(d):
+		return nil, false
+	}
+}
+
+// benchRow is a row of npipe bench -json
+type benchRow struct {
+	Sent            int     `json:"sent"`
+	Received        int     `json:"received"`
+	Errors          int     `json:"errors"`
+	Lost            int     `json:"lost"`
+	Misrouted       int     `json:"misrouted"`
+	Retransmissions uint64  `json:"retransmissions"`
+	Throughput      float64 `json:"throughput"`
+	P50             float64 `json:"p50_ms"`
+	P99             float64 `json:"p99_ms"`
+}
+
+// BenchmarkCallProcesses run npipe bench with a client process per CPU, b.N Calls in all
+func BenchmarkCallProcesses(b *testing.B) {
+	bin := buildNpipe(b)
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			clients := 4
+			out, err := exec.Command(bin, "bench", "-procs", "-json", "-wait", "1s",
+				"-clients", strconv.Itoa(clients), "-requests", strconv.Itoa(b.N/clients+1), "-sizes", strconv.Itoa(size)).Output()
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			var row benchRow
+			if err = json.Unmarshal(out, &row); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(row.Throughput, "req/s")
+			b.ReportMetric(row.P50*1e6, "p50-ns")
+			b.ReportMetric(row.P99*1e6, "p99-ns")
+			b.ReportMetric(float64(row.Retransmissions)/float64(row.Sent), "retran/op")
+			b.ReportMetric(float64(row.Misrouted), "misrouted")
+			b.ReportMetric(float64(row.Lost), "lost")
+		})
+	}
+}
+
+func TestNpipeBench(t *testing.T) {
+	bin := buildNpipe(t)
+
+	for _, args := range [][]string{{"-clients", "1"}, {"-clients", "3"}, {"-clients", "2", "-procs"}} {
+		out, err := exec.Command(bin, append([]string{"bench", "-json", "-requests", "20", "-sizes", "16,1024", "-wait", "1s"}, args...)...).Output()
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("%v: expected a row per size, got %s", args, out)
+		}
+		for _, line := range lines {
+			var row benchRow
+			if err = json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatal(err)
+			}
+			clients, _ := strconv.Atoi(args[1])
+			if row.Sent != 20*clients || row.Received+row.Lost+row.Errors != row.Sent || row.Misrouted != 0 {
+				t.Fatalf("%v: unexpected row %s", args, line)
+			}
+			if clients == 1 && (row.Lost != 0 || row.Retransmissions != 0 || row.P99 <= 0) {
+				t.Fatalf("%v: unexpected row %s", args, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestBridge(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := echoServer(t, ctx, chroot, named_pipe_ipc.WithChecksum(), named_pipe_ipc.WithEncryption(nil))
+	defer server.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge := named_pipe_ipc.NewBridge(ctx, chroot, named_pipe_ipc.WithChecksum())
+	go bridge.Serve(l)
+	defer bridge.Close()
+
+	call := func(client *named_pipe_ipc.Context, name string) {
+		for j := 0; j < 20; j++ {
+			payload := fmt.Sprintf("%s message %d", name, j)
+			reply, err := client.Call(named_pipe_ipc.Message(payload))
+			if err != nil || reply.Payload().String() != payload {
+				t.Errorf("%s: unexpected reply %v %v", name, reply, err)
+				return
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// the remote clients handshake and encrypt through the bridge
+			client, err := named_pipe_ipc.NewContext(ctx, t.TempDir(), named_pipe_ipc.C,
+				named_pipe_ipc.WithTransport(named_pipe_ipc.Dial("tcp", l.Addr().String())),
+				named_pipe_ipc.WithEncryption(nil),
+			)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer client.Close()
+			if _, err = client.Handshake(); err != nil {
+				t.Error(err)
+				return
+			}
+			call(client, fmt.Sprintf("remote %d", i))
+		}(i)
+	}
+
+	// a local client of the FIFOs at the same time
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		local, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		call(local, "local")
+	}()
+	wg.Wait()
+}
+
+func TestBridgeNoServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// no server created the FIFOs of the chroot, a connection gets no client Context
+	var logs syncBuffer
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge := named_pipe_ipc.NewBridge(ctx, t.TempDir(), named_pipe_ipc.WithLogger(slog.NewJSONHandler(&logs, nil)))
+	go bridge.Serve(l)
+	defer bridge.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := conn.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatalf("expected the connection to be closed, got %d bytes %v", n, err)
+	}
+	if !strings.Contains(logs.String(), `"msg":"bridge connection closed, no client Context"`) || !strings.Contains(logs.String(), `"remote":"`+conn.LocalAddr().String()+`"`) {
+		t.Fatalf("the failure was not logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// record return the capture of a server which got the calls of a client, a pause between each
+func record(t *testing.T, pause time.Duration) []byte {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	chroot := t.TempDir()
+
+	var capture syncBuffer
+	server := upperServer(t, ctx, chroot, named_pipe_ipc.WithRecorder(&capture), named_pipe_ipc.WithChecksum())
+	defer server.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithChecksum())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hctx := named_pipe_ipc.ContextWithHeaders(ctx, named_pipe_ipc.Metadata{"topic": "news"})
+	for i, payload := range []string{"nihao", "shijie", "fail"} {
+		if i > 0 {
+			time.Sleep(pause)
+		}
+		if _, err := client.CallContext(hctx, named_pipe_ipc.Message(payload)); err != nil && payload != "fail" {
+			t.Fatal(err)
+		}
+	}
+
+	// the server records its last reply after the client may have read it
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c, err := named_pipe_ipc.ReadCapture(strings.NewReader(capture.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.Records) == 6 || time.Now().After(deadline) {
+			return []byte(capture.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	capture, err := named_pipe_ipc.ReadCapture(bytes.NewReader(record(t, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capture.Role != named_pipe_ipc.S || capture.Delim != '\n' {
+		t.Fatalf("unexpected header %v %q", capture.Role, capture.Delim)
+	}
+
+	var sent, received int
+	for i, record := range capture.Records {
+		if i > 0 && record.Time.Before(capture.Records[i-1].Time) {
+			t.Fatalf("record %d is before the previous one", i)
+		}
+		fi := named_pipe_ipc.Inspect(record.Frame)
+		if fi.Problem != "" || fi.Flags[0] != "checksum" {
+			t.Fatalf("unexpected frame %+v", fi)
+		}
+		if record.Direction == named_pipe_ipc.RecordSent {
+			sent++
+		} else {
+			received++
+		}
+	}
+	if sent != 3 || received != 3 {
+		t.Fatalf("expected 3 frames each way, got %d sent and %d received", sent, received)
+	}
+
+	if _, err = named_pipe_ipc.ReadCapture(strings.NewReader("not a capture")); err == nil {
+		t.Fatal("expected NotCapture")
+	}
+}
+
+func TestCaptureReplay(t *testing.T) {
+	capture, err := named_pipe_ipc.ReadCapture(bytes.NewReader(record(t, 300*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	chroot := t.TempDir()
+	var mu sync.Mutex
+	var headers []string
+	server := serveWith(t, ctx, chroot, named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		mu.Lock()
+		headers = append(headers, message.Header("topic"))
+		mu.Unlock()
+		return named_pipe_ipc.Message(strings.ToUpper(message.Payload().String())), nil
+	}))
+	defer server.Close()
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	results, err := capture.Replay(ctx, client, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("replay at twice the speed took %v, the capture took 600ms", elapsed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(results) != 3 || len(headers) != 3 || headers[0] != "news" {
+		t.Fatalf("unexpected replay %v %v", results, headers)
+	}
+	for _, r := range results[:2] {
+		if !bytes.Equal(r.Reply, r.Recorded) || r.Err != nil {
+			t.Fatalf("unexpected result %+v", r)
+		}
+	}
+	if r := results[2]; r.Recorded.String() != "failed" || r.Reply.String() != "FAIL" {
+		t.Fatalf("unexpected result %+v", r)
+	}
+}
+
+func TestCaptureHandler(t *testing.T) {
+	capture, err := named_pipe_ipc.ReadCapture(bytes.NewReader(record(t, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := capture.Handler(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	chroot := t.TempDir()
+	server, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve(handler)
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"NIHAO", "SHIJIE"} {
+		reply, err := client.Call(named_pipe_ipc.Message("anything"))
+		if err != nil || reply.Payload().String() != want {
+			t.Fatalf("expected %s, got %v %v", want, reply, err)
+		}
+	}
+	if _, err = client.Call(named_pipe_ipc.Message("anything")); err == nil || err.Error() != "failed" {
+		t.Fatalf("expected the recorded error, got %v", err)
+	}
+	if _, err = client.Call(named_pipe_ipc.Message("anything")); err == nil || err.Error() != named_pipe_ipc.CaptureExhaustedMessage {
+		t.Fatalf("expected CaptureExhausted, got %v", err)
+	}
+}
+
+func TestNpipeDumpCapture(t *testing.T) {
+	bin := buildNpipe(t)
+	file := filepath.Join(t.TempDir(), "capture")
+	if err := os.WriteFile(file, record(t, 0), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(bin, "dump", "-json", file).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 records, got %s", out)
+	}
+	var first map[string]interface{}
+	if err = json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first["direction"] != "received" || first["payload"] != "nihao" || first["time"] == nil || first["expired"] == true {
+		t.Fatalf("unexpected record %v", first)
+	}
+}
+
+func TestNpipeReplay(t *testing.T) {
+	bin := buildNpipe(t)
+	chroot := t.TempDir()
+	file := filepath.Join(t.TempDir(), "capture")
+	if err := os.WriteFile(file, record(t, 0), 0600); err != nil {
+		t.Fatal(err)
+	}
+	startNpipe(t, bin, &syncBuffer{}, "serve", "-chroot", chroot, "-timeout", "10s", "--exec", "tr a-z A-Z")
+
+	out, err := exec.Command(bin, "replay", "-chroot", chroot, "-timeout", "5s", "-speed", "0", file).Output()
+	if code := exitCode(err); code != 1 {
+		t.Fatalf("expected exit code 1 for the reply which differs, got %d", code)
+	}
+	if !strings.Contains(string(out), `1 "nihao" ok "NIHAO"`) || !strings.Contains(string(out), `3 "fail" DIFF "FAIL" recorded "failed"`) {
+		t.Fatalf("unexpected output %s", out)
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	uuid2 "github.com/satori/go.uuid"
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// checksumFrame build a version 1 frame with a checksum, byteLength count the delim
+func checksumFrame(payload string, delim byte) []byte {
+	buf := make([]byte, 8)
+	buf = append(buf, protoFlag...)
+	buf = append(buf, 1, 1<<1, protoNormalType)
+	buf = append(buf, uuid2.NewV4().Bytes()...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(time.Now().Unix()+10))
+	buf = append(buf, 1, 0, 0)
+	buf = append(buf, payload...)
+	binary.BigEndian.PutUint64(buf, uint64(len(buf)+4+1))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf, crc32.MakeTable(crc32.Castagnoli)))
+
+	return append(buf, delim)
+}
+
+func TestChecksum(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := echoServer(t, ctx, chroot, named_pipe_ipc.WithChecksum())
+	defer server.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithChecksum())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := client.Call(named_pipe_ipc.Message("nihao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Payload().String() != "nihao" {
+		t.Errorf("unexpected reply %q", reply.Payload().String())
+	}
+
+	// a writer which flips a byte of the content
+	pipe, err := os.OpenFile(filepath.Join(chroot, server.NamedPipeForRead()), os.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+	frame := checksumFrame("corrupted", '\n')
+	frame[len(frame)-8] ^= 0xff
+	if _, err = pipe.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err = client.Call(named_pipe_ipc.Message("again"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Payload().String() != "again" {
+		t.Errorf("unexpected reply %q", reply.Payload().String())
+	}
+	if n := server.Stats().ChecksumMismatch; n != 1 {
+		t.Errorf("unexpected checksum mismatch %d", n)
+	}
+}
+
+// checksummedMetadataFrame build a frame of client whose metadata announces 4 bytes, the right checksum being all it has
+func checksummedMetadataFrame(flags byte, client uuid2.UUID) []byte {
+	buf := make([]byte, 8)
+	buf = append(buf, protoFlag...)
+	buf = append(buf, 1, 1<<1|flags, protoNormalType)
+	buf = append(buf, client.Bytes()...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(time.Now().Unix()+10))
+	buf = append(buf, 1, 0, 4)
+	binary.BigEndian.PutUint64(buf, uint64(len(buf)+4+1))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf, crc32.MakeTable(crc32.Castagnoli)))
+
+	return append(buf, '\n')
+}
+
+func TestChecksumMalformed(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := echoServer(t, ctx, chroot)
+	defer server.Close()
+
+	// the checksum is right, without it the frame is too short for its metadata
+	writeFifo(t, server, checksummedMetadataFrame(0, uuid2.NewV4()))
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := client.Call(named_pipe_ipc.Message("nihao")); err != nil || reply.Payload().String() != "nihao" {
+		t.Fatalf("unexpected reply %v %v", reply, err)
+	}
+	if stats := server.Stats(); stats.Malformed == 0 || stats.ChecksumMismatch != 0 {
+		t.Fatalf("unexpected stats %d malformed %d checksum mismatches", stats.Malformed, stats.ChecksumMismatch)
+	}
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"compress/gzip"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestCompression(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	compression := named_pipe_ipc.WithCompression(named_pipe_ipc.Gzip{Level: gzip.BestSpeed}, 256)
+	server := echoServer(t, ctx, chroot, compression)
+	defer server.Close()
+
+	payload := strings.Repeat(`{"name":"named-pipe-ipc","compressed":true},`, 1000)
+
+	// without compression on the client side, the server negotiates it off
+	plain, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = plain.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := plain.Call(named_pipe_ipc.Message(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Payload().String() != payload {
+		t.Error("unexpected reply")
+	}
+	if plain.Stats().BytesReceived < uint64(len(payload)) {
+		t.Error("reply of a client without compression is compressed")
+	}
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, compression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	capabilities, err := client.Handshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !capabilities.Has("compress/gzip") {
+		t.Fatalf("compression not agreed %v", capabilities.Features)
+	}
+	reply, err = client.Call(named_pipe_ipc.Message(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Payload().String() != payload {
+		t.Error("unexpected reply")
+	}
+	if reply.Header("content-encoding") != "" {
+		t.Error("content-encoding left on the decompressed frame")
+	}
+	stats := client.Stats()
+	if stats.BytesSent > uint64(len(payload))/10 || stats.BytesReceived > uint64(len(payload))/10 {
+		t.Errorf("payload not compressed, sent %d received %d", stats.BytesSent, stats.BytesReceived)
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestResync(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := echoServer(t, ctx, chroot, named_pipe_ipc.WithMaxFrameLength(1<<20))
+	defer server.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipe, err := os.OpenFile(filepath.Join(chroot, server.NamedPipeForRead()), os.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+
+	// short fragments, a bogus length behind a flag and a frame cut by the next one
+	var garbage []byte
+	garbage = append(garbage, "\n\nabc\n"...)
+	garbage = binary.BigEndian.AppendUint64(garbage, 1<<40)
+	garbage = append(garbage, protoFlag...)
+	garbage = append(garbage, '\n')
+	garbage = append(garbage, checksumFrame("cut", '\n')[:30]...)
+	if _, err = pipe.Write(garbage); err != nil {
+		t.Fatal(err)
+	}
+
+	// byteLength 266 ends with the delim
+	for _, payload := range []string{"nihao", strings.Repeat("x", 213)} {
+		reply, err := client.Call(named_pipe_ipc.Message(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Payload().String() != payload {
+			t.Errorf("unexpected reply %q", reply.Payload().String())
+		}
+	}
+
+	if server.Stats().Malformed == 0 {
+		t.Error("resync not counted")
+	}
+}
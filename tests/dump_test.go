@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	uuid2 "github.com/satori/go.uuid"
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// captureTransport keep what a client writes, as the byte stream of a FIFO
+type captureTransport struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newCaptureTransport() *captureTransport {
+	return &captureTransport{closed: make(chan struct{})}
+}
+
+func (t *captureTransport) Open(*named_pipe_ipc.Context) error {
+	return nil
+}
+
+func (t *captureTransport) Read() (named_pipe_ipc.Message, named_pipe_ipc.Peer, error) {
+	<-t.closed
+	return nil, named_pipe_ipc.Peer{}, os.ErrClosed
+}
+
+func (t *captureTransport) Write(frame []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf.Write(frame)
+	return nil
+}
+
+func (t *captureTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+func (t *captureTransport) Bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]byte(nil), t.buf.Bytes()...)
+}
+
+// capture return the stream of the frames a client sends with opts
+func capture(t *testing.T, send func(client *named_pipe_ipc.Context), opts ...named_pipe_ipc.Option) []byte {
+	t.Helper()
+	transport := newCaptureTransport()
+	client, err := named_pipe_ipc.NewContext(context.Background(), t.TempDir(), named_pipe_ipc.C, append(opts, named_pipe_ipc.WithTransport(transport))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	send(client)
+
+	return transport.Bytes()
+}
+
+// dumpStream is a stream of a good frame, garbage, an expired frame with a header, a corrupted checksum and a cut frame
+func dumpStream(t *testing.T) []byte {
+	var stream []byte
+	stream = append(stream, capture(t, func(client *named_pipe_ipc.Context) {
+		client.Send(named_pipe_ipc.Message("nihao"))
+	})...)
+	stream = append(stream, "garbage"...)
+	stream = append(stream, capture(t, func(client *named_pipe_ipc.Context) {
+		ctx := named_pipe_ipc.ContextWithHeaders(context.Background(), named_pipe_ipc.Metadata{"topic": "news"})
+		client.SendContext(ctx, named_pipe_ipc.Message{0xff, 0x00, 0x01})
+	}, named_pipe_ipc.WithTTL(-time.Minute))...)
+
+	corrupted := capture(t, func(client *named_pipe_ipc.Context) {
+		client.Send(named_pipe_ipc.Message("shijie"))
+	}, named_pipe_ipc.WithChecksum())
+	corrupted[len(corrupted)-3] ^= 0xff
+	stream = append(stream, corrupted...)
+	stream = append(stream, checksummedMetadataFrame(0, uuid2.NewV4())...)
+
+	cut := capture(t, func(client *named_pipe_ipc.Context) {
+		client.Send(named_pipe_ipc.Message("cut"))
+	})
+	return append(stream, cut[:len(cut)-5]...)
+}
+
+func TestDecoder(t *testing.T) {
+	stream := dumpStream(t)
+	decoder := named_pipe_ipc.NewDecoder(bytes.NewReader(stream), '\n')
+
+	var infos []named_pipe_ipc.FrameInfo
+	var malformed []named_pipe_ipc.MalformedFrame
+	var end int64
+	for {
+		frame, offset, err := decoder.Next()
+		var m named_pipe_ipc.MalformedFrame
+		if errors.As(err, &m) {
+			malformed = append(malformed, m)
+			end = m.Offset + int64(m.Length)
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi := named_pipe_ipc.Inspect(frame)
+		infos = append(infos, fi)
+		end = offset + fi.Length
+	}
+
+	if end != int64(len(stream)) {
+		t.Fatalf("the records cover %d bytes of %d", end, len(stream))
+	}
+	if len(infos) != 4 || len(malformed) != 2 {
+		t.Fatalf("expected 4 frames and 2 malformed, got %d and %d", len(infos), len(malformed))
+	}
+	if malformed[1].Reason != "truncated frame" {
+		t.Fatalf("expected a truncated frame, got %q", malformed[1].Reason)
+	}
+
+	now := time.Now()
+	if fi := infos[0]; fi.Type != '0' || fi.TypeName != "normal" || fi.Payload.String() != "nihao" || fi.Expired(now) || fi.Problem != "" {
+		t.Fatalf("unexpected first frame %+v", fi)
+	}
+	if fi := infos[1]; !fi.Expired(now) || fi.Headers["topic"] != "news" || !bytes.Equal(fi.Payload, []byte{0xff, 0x00, 0x01}) {
+		t.Fatalf("unexpected second frame %+v", fi)
+	}
+	if fi := infos[2]; fi.Problem != named_pipe_ipc.ChecksumMismatchMessage || len(fi.Flags) != 1 || fi.Flags[0] != "checksum" {
+		t.Fatalf("unexpected third frame %+v", fi)
+	}
+	if fi := infos[3]; fi.Problem != "malformed after checksum" || fi.Payload != nil {
+		t.Fatalf("unexpected fourth frame %+v", fi)
+	}
+}
+
+func TestNpipeDump(t *testing.T) {
+	bin := buildNpipe(t)
+	file := filepath.Join(t.TempDir(), "capture")
+	if err := os.WriteFile(file, dumpStream(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(bin, "dump", "-json", file).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("%v: %s", err, scanner.Bytes())
+		}
+		records = append(records, record)
+	}
+	if len(records) != 6 {
+		t.Fatalf("expected 6 records, got %s", out)
+	}
+
+	if r := records[0]; r["payload"] != "nihao" || r["encoding"] != "utf-8" || r["type"] != "0" || r["client"] == "" {
+		t.Fatalf("unexpected record %v", r)
+	}
+	if r := records[1]; r["malformed"] != "no flag" {
+		t.Fatalf("unexpected record %v", r)
+	}
+	if r := records[2]; r["expired"] != true || r["payload"] != "ff0001" || r["encoding"] != "hex" {
+		t.Fatalf("unexpected record %v", r)
+	}
+	if r := records[3]; r["problem"] != named_pipe_ipc.ChecksumMismatchMessage {
+		t.Fatalf("unexpected record %v", r)
+	}
+	if r := records[4]; r["problem"] != "malformed after checksum" || r["payload"] != nil {
+		t.Fatalf("unexpected record %v", r)
+	}
+	if r := records[5]; r["malformed"] != "truncated frame" {
+		t.Fatalf("unexpected record %v", r)
+	}
+
+	out, err = exec.Command(bin, "dump", "-preview", "2", file).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte(`payload(5)="ni"...`)) || !bytes.Contains(out, []byte("EXPIRED")) || !bytes.Contains(out, []byte("MALFORMED no flag")) {
+		t.Fatalf("unexpected output %s", out)
+	}
+}
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// lockFifo flock(2) how the FIFO name of server, as another client would, and return the unlock
+func lockFifo(t *testing.T, server *named_pipe_ipc.Context, name string, how int) func() {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(server.Chroot(), name), os.O_RDWR, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = syscall.Flock(int(f.Fd()), how); err != nil {
+		t.Fatal(err)
+	}
+
+	var once sync.Once
+	unlock := func() {
+		once.Do(func() { f.Close() })
+	}
+	t.Cleanup(unlock)
+
+	return unlock
+}
+
+// blocked tell if f is still running after a while
+func blocked(f func()) (bool, chan struct{}) {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return false, done
+	case <-time.After(200 * time.Millisecond):
+		return true, done
+	}
+}
+
+func TestFifoWriteLock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	chroot := t.TempDir()
+	server := echoServer(t, ctx, chroot)
+	defer server.Close()
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small := named_pipe_ipc.Message("small")
+	large := named_pipe_ipc.Message(strings.Repeat("x", 8192))
+
+	// a client writing a large frame holds the lock exclusively, even the small frames wait
+	unlock := lockFifo(t, server, server.NamedPipeForRead(), syscall.LOCK_EX)
+	if ok, _ := blocked(func() { client.Send(small) }); !ok {
+		t.Fatal("a small frame was written during an exclusive lock")
+	}
+	unlock()
+
+	// the small frames share the lock, a large frame waits for them
+	unlock = lockFifo(t, server, server.NamedPipeForRead(), syscall.LOCK_SH)
+	if ok, _ := blocked(func() { client.Send(small) }); ok {
+		t.Fatal("a small frame waited for a shared lock")
+	}
+	ok, done := blocked(func() { client.Send(large) })
+	if !ok {
+		t.Fatal("a large frame was written during a shared lock")
+	}
+	unlock()
+	<-done
+}
+
+func TestFifoReadLock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	chroot := t.TempDir()
+	server := echoServer(t, ctx, chroot)
+	defer server.Close()
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another client is reading a frame, the reply waits in the FIFO
+	unlock := lockFifo(t, server, server.NamedPipeForWrite(), syscall.LOCK_EX)
+	var reply named_pipe_ipc.Message
+	ok, done := blocked(func() { reply, err = client.Call(named_pipe_ipc.Message("nihao")) })
+	if !ok {
+		t.Fatal("a frame was read during the lock of another client")
+	}
+	unlock()
+	<-done
+	if err != nil || reply.Payload().String() != "nihao" {
+		t.Fatalf("unexpected reply %v %v", reply, err)
+	}
+}
+
+func TestFifoSharedClients(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	chroot := t.TempDir()
+	server := echoServer(t, ctx, chroot)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i, size := range []int{16, 4000, 4097, 65536} {
+		client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i, size int) {
+			defer wg.Done()
+			for n := 0; n < 30; n++ {
+				payload := fmt.Sprintf("%d-%d|", i, n)
+				payload += strings.Repeat("x", size-len(payload))
+				reply, err := client.Call(named_pipe_ipc.Message(payload))
+				if err != nil || reply.Payload().String() != payload {
+					t.Errorf("client %d: unexpected reply %.20q %v", i, reply, err)
+					return
+				}
+			}
+			if malformed := client.Stats().Malformed; malformed != 0 {
+				t.Errorf("client %d: %d malformed frames", i, malformed)
+			}
+		}(i, size)
+	}
+	wg.Wait()
+
+	if malformed := server.Stats().Malformed; malformed != 0 {
+		t.Fatalf("server: %d malformed frames", malformed)
+	}
+}
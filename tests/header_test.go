@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestHeaders(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve(named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		return named_pipe_ipc.Message(message.Header(named_pipe_ipc.HeaderTenant)), nil
+	}), named_pipe_ipc.WithReplyHeaders(named_pipe_ipc.HeaderReplyTo))
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hctx := named_pipe_ipc.ContextWithHeaders(ctx, named_pipe_ipc.Metadata{
+		named_pipe_ipc.HeaderTenant:  "acme",
+		named_pipe_ipc.HeaderReplyTo: "inbox-1",
+	})
+	reply, err := client.CallContext(hctx, named_pipe_ipc.Message("nihao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Payload().String() != "acme" {
+		t.Errorf("unexpected reply %q", reply.Payload().String())
+	}
+	if reply.Header(named_pipe_ipc.HeaderReplyTo) != "inbox-1" {
+		t.Errorf("reply-to not copied: %v", reply.Headers())
+	}
+	if reply.Header(named_pipe_ipc.HeaderTenant) != "" {
+		t.Errorf("tenant should not be copied: %v", reply.Headers())
+	}
+
+	changed, err := reply.SetHeader(named_pipe_ipc.HeaderContentType, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Header(named_pipe_ipc.HeaderContentType) != "text/plain" || changed.Header(named_pipe_ipc.HeaderReplyTo) != "inbox-1" {
+		t.Errorf("unexpected headers %v", changed.Headers())
+	}
+	if changed.Payload().String() != "acme" {
+		t.Errorf("unexpected payload %q", changed.Payload().String())
+	}
+	if reply.Header(named_pipe_ipc.HeaderContentType) != "" {
+		t.Error("SetHeader changed the original frame")
+	}
+}
@@ -0,0 +1,276 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// helperTimeout is how long a helper process has to answer a command
+const helperTimeout = 20 * time.Second
+
+// buildHelpers build the ipcserver and ipcclient helpers of testdata into a temporary directory
+func buildHelpers(t *testing.T) (server, client string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"ipcserver", "ipcclient"} {
+		if out, err := exec.Command("go", "build", "-o", filepath.Join(dir, name), "./testdata/"+name).CombinedOutput(); err != nil {
+			t.Fatalf("go build %s: %v\n%s", name, err, out)
+		}
+	}
+
+	return filepath.Join(dir, "ipcserver"), filepath.Join(dir, "ipcclient")
+}
+
+// helper is a running helper process, its stdout read line by line
+type helper struct {
+	t     *testing.T
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan string
+	done  chan struct{}
+}
+
+// startHelper start bin and wait for it to print "ready"
+func startHelper(t *testing.T, bin string, args ...string) *helper {
+	t.Helper()
+	h := &helper{t: t, cmd: exec.Command(bin, args...), lines: make(chan string, 16), done: make(chan struct{})}
+	h.cmd.Stderr = os.Stderr
+	stdout, err := h.cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.stdin, err = h.cmd.StdinPipe(); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Wait closes stdout, it is only called once every line is read
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			h.lines <- scanner.Text()
+		}
+		close(h.lines)
+		h.cmd.Wait()
+		close(h.done)
+	}()
+	t.Cleanup(h.kill)
+
+	if line := h.line(); line != "ready" {
+		t.Fatalf("%s: expected ready, got %q", filepath.Base(bin), line)
+	}
+
+	return h
+}
+
+// line return the next line the helper printed, "" if it printed none in time
+func (h *helper) line() string {
+	select {
+	case line := <-h.lines:
+		return line
+	case <-time.After(helperTimeout):
+		return ""
+	}
+}
+
+// do send a command to the helper and return the line it answered
+func (h *helper) do(command string) string {
+	if _, err := fmt.Fprintln(h.stdin, command); err != nil {
+		return "error " + err.Error()
+	}
+
+	return h.line()
+}
+
+// expect fail the test unless the helper answers command with want
+func (h *helper) expect(command, want string) {
+	h.t.Helper()
+	if got := h.do(command); got != want {
+		h.t.Fatalf("%s: expected %q, got %q", command, want, got)
+	}
+}
+
+// stop send sig to the helper and wait for it to exit
+func (h *helper) stop(sig syscall.Signal) {
+	h.t.Helper()
+	h.cmd.Process.Signal(sig)
+	select {
+	case <-h.done:
+	case <-time.After(helperTimeout):
+		h.t.Fatalf("still running after %v", sig)
+	}
+}
+
+func (h *helper) kill() {
+	h.stdin.Close()
+	h.cmd.Process.Kill()
+	for range h.lines {
+	}
+	<-h.done
+}
+
+// fifosExist tell if the FIFOs of a server are in chroot
+func fifosExist(chroot string) bool {
+	entries, _ := os.ReadDir(chroot)
+	return len(entries) > 0
+}
+
+func TestIntegrationConcurrentClients(t *testing.T) {
+	serverBin, clientBin := buildHelpers(t)
+	chroot := t.TempDir()
+	startHelper(t, serverBin, "-chroot", chroot)
+
+	// the frames under PIPE_BUF and over it
+	sizes := []int{16, 512, 4000, 16384}
+	var clients []*helper
+	for i := 0; i < 8; i++ {
+		clients = append(clients, startHelper(t, clientBin, "-chroot", chroot, "-id", fmt.Sprintf("client%d", i)))
+	}
+
+	var wg sync.WaitGroup
+	answers := make([]string, len(clients))
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *helper) {
+			defer wg.Done()
+			answers[i] = client.do(fmt.Sprintf("call 50 %d", sizes[i%len(sizes)]))
+		}(i, client)
+	}
+	wg.Wait()
+
+	for i, answer := range answers {
+		if answer != "ok 50" {
+			t.Errorf("client%d: %s", i, answer)
+		}
+	}
+}
+
+func TestIntegrationServerRestart(t *testing.T) {
+	serverBin, clientBin := buildHelpers(t)
+	chroot := t.TempDir()
+	server := startHelper(t, serverBin, "-chroot", chroot)
+	client := startHelper(t, clientBin, "-chroot", chroot)
+	client.expect("call 5 64", "ok 5")
+
+	// a crashed server leaves its FIFOs, the next one opens them again and the client goes on
+	server.stop(syscall.SIGKILL)
+	if !fifosExist(chroot) {
+		t.Fatal("the FIFOs of the killed server are gone")
+	}
+	startHelper(t, serverBin, "-chroot", chroot)
+	client.expect("call 5 64", "ok 5")
+}
+
+func TestIntegrationClientCrash(t *testing.T) {
+	serverBin, clientBin := buildHelpers(t)
+	chroot := t.TempDir()
+	startHelper(t, serverBin, "-chroot", chroot)
+
+	// the client dies while it waits for its reply, the reply goes around until its ttl is over
+	crashed := startHelper(t, clientBin, "-chroot", chroot, "-id", "crashed")
+	fmt.Fprintln(crashed.stdin, "sleep 200ms")
+	time.Sleep(50 * time.Millisecond)
+	crashed.stop(syscall.SIGKILL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		client := startHelper(t, clientBin, "-chroot", chroot, "-id", fmt.Sprintf("client%d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if answer := client.do("call 20 256"); answer != "ok 20" {
+				t.Error(answer)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIntegrationContextCancel(t *testing.T) {
+	serverBin, clientBin := buildHelpers(t)
+	chroot := t.TempDir()
+	startHelper(t, serverBin, "-chroot", chroot)
+
+	// the Context of the client is over before the reply comes
+	client := startHelper(t, clientBin, "-chroot", chroot, "-timeout", "300ms")
+	start := time.Now()
+	answer := client.do("sleep 2s")
+	if !strings.HasPrefix(answer, "error") || !strings.Contains(answer, "deadline exceeded") {
+		t.Fatalf("expected the deadline of the Context, got %q", answer)
+	}
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Fatalf("the canceled call returned after %v", elapsed)
+	}
+	if answer := client.do("call 1 16"); !strings.HasPrefix(answer, "error call 0") || !strings.Contains(answer, "closed") {
+		t.Fatalf("expected the client to be closed, got %q", answer)
+	}
+
+	// the late reply goes around the other clients, none takes it for its own
+	other := startHelper(t, clientBin, "-chroot", chroot)
+	other.expect("call 10 64", "ok 10")
+	time.Sleep(2 * time.Second)
+	other.expect("call 10 64", "ok 10")
+}
+
+func TestIntegrationLargePayload(t *testing.T) {
+	serverBin, clientBin := buildHelpers(t)
+	chroot := t.TempDir()
+	startHelper(t, serverBin, "-chroot", chroot)
+
+	client := startHelper(t, clientBin, "-chroot", chroot)
+	for _, size := range []int{4096, 65536, 1 << 20, 8 << 20} {
+		client.expect(fmt.Sprintf("call 3 %d", size), "ok 3")
+	}
+
+	// the frames of several clients, many times PIPE_BUF, do not interleave
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		client := startHelper(t, clientBin, "-chroot", chroot, "-id", fmt.Sprintf("client%d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if answer := client.do("call 5 262144"); answer != "ok 5" {
+				t.Error(answer)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIntegrationClose(t *testing.T) {
+	serverBin, clientBin := buildHelpers(t)
+	chroot := t.TempDir()
+	server := startHelper(t, serverBin, "-chroot", chroot)
+	startHelper(t, clientBin, "-chroot", chroot).expect("call 5 64", "ok 5")
+
+	server.stop(syscall.SIGTERM)
+	if line := server.line(); line != "closed" {
+		t.Fatalf("expected the server to close, got %q", line)
+	}
+	if code := server.cmd.ProcessState.ExitCode(); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	if fifosExist(chroot) {
+		t.Fatal("Close left the FIFOs")
+	}
+
+	// a new server creates them again, the Close of a client leaves them to the server and its other clients
+	startHelper(t, serverBin, "-chroot", chroot)
+	client := startHelper(t, clientBin, "-chroot", chroot)
+	client.expect("call 5 64", "ok 5")
+	client.expect("close", "ok")
+	if !fifosExist(chroot) {
+		t.Fatal("Close of the client removed the FIFOs of the server")
+	}
+	startHelper(t, clientBin, "-chroot", chroot).expect("call 5 64", "ok 5")
+}
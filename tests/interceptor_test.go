@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestInterceptors(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var order []string
+	auth := func(ctx context.Context, message named_pipe_ipc.Message, next named_pipe_ipc.Invoker) (named_pipe_ipc.Message, error) {
+		order = append(order, "auth")
+		if message.Payload().String() == "deny" {
+			return nil, errors.New("denied")
+		}
+		return next(ctx, message)
+	}
+	suffix := func(ctx context.Context, message named_pipe_ipc.Message, next named_pipe_ipc.Invoker) (named_pipe_ipc.Message, error) {
+		order = append(order, "suffix")
+		reply, err := next(ctx, message)
+		return append(reply, '!'), err
+	}
+
+	server := echoServer(t, ctx, chroot, named_pipe_ipc.WithServerInterceptors(auth, suffix))
+	defer server.Close()
+
+	var sent, received int
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithClientInterceptors(
+		func(ctx context.Context, message named_pipe_ipc.Message, next named_pipe_ipc.Invoker) (named_pipe_ipc.Message, error) {
+			if named_pipe_ipc.Receiving(ctx) {
+				received++
+			} else {
+				sent++
+			}
+			return next(ctx, message)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.Send(named_pipe_ipc.Message("nihao")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := client.Recv(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Payload().String() != "nihao!" {
+		t.Errorf("unexpected reply %q", msg.Payload().String())
+	}
+
+	if _, err = client.Send(named_pipe_ipc.Message("deny")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Recv(true); err == nil || err.Error() != "denied" {
+		t.Errorf("expect denied, got %v", err)
+	}
+
+	if _, err = client.Call(named_pipe_ipc.Message("nihao")); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent != 3 || received != 3 {
+		t.Errorf("client interceptor run around %d sends and %d receives", sent, received)
+	}
+	if len(order) != 5 || order[0] != "auth" || order[1] != "suffix" || order[2] != "auth" {
+		t.Errorf("unexpected order %v", order)
+	}
+}
+
+func TestInterceptorsRecv(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// a server which does not Serve runs its interceptors around Recv
+	server, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.S, named_pipe_ipc.WithServerInterceptors(
+		func(ctx context.Context, message named_pipe_ipc.Message, next named_pipe_ipc.Invoker) (named_pipe_ipc.Message, error) {
+			if !named_pipe_ipc.Receiving(ctx) || message != nil {
+				return nil, errors.New("not a receive")
+			}
+			frame, err := next(ctx, message)
+			if err == nil && frame.Payload().String() == "deny" {
+				return nil, errors.New("denied")
+			}
+			return frame, err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Listen()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, payload := range []string{"nihao", "deny"} {
+		if _, err = client.Send(named_pipe_ipc.Message(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if frame, err := server.Recv(true); err != nil || frame.Payload().String() != "nihao" {
+		t.Fatalf("unexpected frame %q %v", frame, err)
+	}
+	if _, err := server.Recv(true); err == nil || err.Error() != "denied" {
+		t.Fatalf("expect denied, got %v", err)
+	}
+}
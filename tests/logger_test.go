@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// logEvents return the JSON log events of logs whose message is msg
+func logEvents(t *testing.T, logs string, msg string) []map[string]any {
+	t.Helper()
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("%v: %q", err, line)
+		}
+		if event["msg"] == msg {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+func TestLogger(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var serverLogs, clientLogs syncBuffer
+	debug := &slog.HandlerOptions{Level: slog.LevelDebug}
+	server := echoServer(t, ctx, chroot, named_pipe_ipc.WithLogger(slog.NewJSONHandler(&serverLogs, debug)))
+	defer server.Close()
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithLogger(slog.NewJSONHandler(&clientLogs, debug)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := client.Call(named_pipe_ipc.Message("nihao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := named_pipe_ipc.Inspect(reply).ClientID
+
+	// garbage in front of a request, and an expired frame in front of its reply
+	writeFifo(t, server, []byte("garbage"))
+	expired := capture(t, func(client *named_pipe_ipc.Context) {
+		client.Send(named_pipe_ipc.Message("late"))
+	}, named_pipe_ipc.WithTTL(-time.Minute))
+	pipe, err := os.OpenFile(filepath.Join(chroot, server.NamedPipeForWrite()), os.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pipe.Write(spoofed(expired, id)); err != nil {
+		t.Fatal(err)
+	}
+	pipe.Close()
+	if _, err = client.Call(named_pipe_ipc.Message("shijie")); err != nil {
+		t.Fatal(err)
+	}
+
+	resyncs := logEvents(t, serverLogs.String(), "bytes dropped, resync")
+	if len(resyncs) == 0 || resyncs[0]["level"] != "WARN" || resyncs[0]["skipped"] != float64(len("garbage")) || resyncs[0]["reason"] != "no flag" {
+		t.Fatalf("unexpected resync events %v", resyncs)
+	}
+	drops := logEvents(t, clientLogs.String(), "frame dropped, ttl expired")
+	if len(drops) != 1 || drops[0]["level"] != "WARN" || drops[0]["client"] != id.String() || drops[0]["type"] != "0" {
+		t.Fatalf("unexpected drop events %v", drops)
+	}
+	if ttl, _ := drops[0]["ttl"].(float64); int64(ttl) >= time.Now().Unix() {
+		t.Fatalf("unexpected drop events %v", drops)
+	}
+	if opened := logEvents(t, serverLogs.String(), "fifo opened"); len(opened) == 0 {
+		t.Fatalf("the FIFOs of the server were not logged as opened:\n%s", serverLogs.String())
+	}
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestPair(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// delayed frames written a few bytes at a time are read as they were written
+	server, client, err := named_pipe_ipc.NewPair(ctx, named_pipe_ipc.WithFaults(named_pipe_ipc.Faults{Delay: time.Millisecond, ChunkSize: 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	defer client.Close()
+	go server.Serve(named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		return message.Payload(), nil
+	}))
+
+	for i := 0; i < 20; i++ {
+		payload := fmt.Sprintf("message %d", i)
+		reply, err := client.Call(named_pipe_ipc.Message(payload))
+		if err != nil || reply.Payload().String() != payload {
+			t.Fatalf("unexpected reply %v %v", reply, err)
+		}
+	}
+}
+
+func TestPairFaults(t *testing.T) {
+	cases := []struct {
+		name   string
+		faults named_pipe_ipc.Faults
+	}{
+		{"drop", named_pipe_ipc.Faults{DropRate: 0.3, Seed: 1}},
+		{"partial", named_pipe_ipc.Faults{PartialRate: 0.3, Seed: 2}},
+		{"corrupt", named_pipe_ipc.Faults{CorruptRate: 0.3, Seed: 3}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			server, client, err := named_pipe_ipc.NewPair(ctx, named_pipe_ipc.WithChecksum(), named_pipe_ipc.WithFaults(c.faults))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+			defer client.Close()
+
+			var mu sync.Mutex
+			handled := make(map[string]bool)
+			go server.Serve(named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				handled[message.Payload().String()] = true
+				return nil, nil
+			}))
+
+			sent := make(map[string]bool)
+			for i := 0; i < 100; i++ {
+				payload := fmt.Sprintf("message %03d %s", i, strings.Repeat("x", 50))
+				sent[payload] = true
+				if _, err = client.Send(named_pipe_ipc.Message(payload)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// wait until the server handles nothing more
+			n := -1
+			for {
+				time.Sleep(100 * time.Millisecond)
+				mu.Lock()
+				m := len(handled)
+				mu.Unlock()
+				if m == n {
+					break
+				}
+				n = m
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(handled) == 0 || len(handled) == len(sent) {
+				t.Fatalf("expected some frames lost, %d of %d handled", len(handled), len(sent))
+			}
+			for payload := range handled {
+				if !sent[payload] {
+					t.Fatalf("damaged frame handled: %q", payload)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildNpipe build the npipe command into a temporary directory
+func buildNpipe(t testing.TB) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "npipe")
+	if out, err := exec.Command("go", "build", "-o", bin, "../cmd/npipe").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	return bin
+}
+
+// startNpipe start a long-running npipe command and wait for it to create its FIFOs
+func startNpipe(t *testing.T, bin string, stdout *syncBuffer, args ...string) {
+	t.Helper()
+	cmd := exec.Command(bin, args...)
+	cmd.Stdout = stdout
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	time.Sleep(300 * time.Millisecond)
+}
+
+func TestNpipeServeCall(t *testing.T) {
+	bin := buildNpipe(t)
+	chroot := t.TempDir()
+	startNpipe(t, bin, &syncBuffer{}, "serve", "-chroot", chroot, "-timeout", "10s", "--exec", `tr a-z A-Z; echo "$NPIPE_HEADER_FOO" >&2`)
+
+	out, err := exec.Command(bin, "call", "-chroot", chroot, "-timeout", "5s", "nihao", "shijie").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "NIHAO SHIJIE\n" {
+		t.Fatalf("unexpected reply %q", out)
+	}
+
+	cmd := exec.Command(bin, "call", "-chroot", chroot, "-timeout", "5s")
+	cmd.Stdin = strings.NewReader("from stdin")
+	if out, err = cmd.Output(); err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "FROM STDIN\n" {
+		t.Fatalf("unexpected reply %q", out)
+	}
+}
+
+func TestNpipeServeError(t *testing.T) {
+	bin := buildNpipe(t)
+	chroot := t.TempDir()
+	startNpipe(t, bin, &syncBuffer{}, "serve", "-chroot", chroot, "-timeout", "10s", "--exec", "echo boom >&2; exit 3")
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(bin, "call", "-chroot", chroot, "-timeout", "5s", "x")
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if code := exitCode(err); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "boom") {
+		t.Fatalf("expected the stderr of the server, got %q", stderr.String())
+	}
+}
+
+func TestNpipeSendListen(t *testing.T) {
+	bin := buildNpipe(t)
+	chroot := t.TempDir()
+	stdout := &syncBuffer{}
+	startNpipe(t, bin, stdout, "listen", "-chroot", chroot, "-read", "l.r", "-write", "l.w", "-delim", `\x00`, "-timeout", "10s")
+
+	cmd := exec.Command(bin, "send", "-chroot", chroot, "-read", "l.r", "-write", "l.w", "-delim", `\x00`, "-timeout", "5s")
+	cmd.Stdin = strings.NewReader("first\nline")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(stdout.String(), "first\nline") {
+		if time.Now().After(deadline) {
+			t.Fatalf("listen did not print the payload, got %q", stdout.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestNpipeUsage(t *testing.T) {
+	bin := buildNpipe(t)
+
+	for _, args := range [][]string{nil, {"nope"}, {"send", "-nope"}} {
+		if code := exitCode(exec.Command(bin, args...).Run()); code != 2 {
+			t.Errorf("npipe %v: expected exit code 2, got %d", args, code)
+		}
+	}
+}
+
+func exitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+
+	return 0
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestFifoMode(t *testing.T) {
+	chroot := t.TempDir()
+	server, err := named_pipe_ipc.NewContext(context.Background(), chroot, named_pipe_ipc.S, named_pipe_ipc.WithFifoMode(0660))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	for _, name := range []string{server.NamedPipeForRead(), server.NamedPipeForWrite()} {
+		s, err := os.Stat(filepath.Join(chroot, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Mode().Type() != os.ModeNamedPipe || s.Mode().Perm() != 0660 {
+			t.Fatalf("unexpected mode %v", s.Mode())
+		}
+	}
+}
+
+func TestFifoReuse(t *testing.T) {
+	cases := []struct {
+		name    string
+		prepare func(path string) error
+		err     error
+	}{
+		{"private fifo", func(path string) error {
+			return syscall.Mkfifo(path, 0600)
+		}, nil},
+		{"permissive fifo", func(path string) error {
+			if err := syscall.Mkfifo(path, 0600); err != nil {
+				return err
+			}
+			return os.Chmod(path, os.ModeNamedPipe|0666)
+		}, named_pipe_ipc.PermissiveMode{}},
+		{"not a fifo", func(path string) error {
+			return os.WriteFile(path, nil, 0600)
+		}, named_pipe_ipc.AlreadyExistButNotNamedPipe{}},
+		{"fifo of another user", func(path string) error {
+			if os.Geteuid() != 0 {
+				t.Skip("chown needs root")
+			}
+			if err := syscall.Mkfifo(path, 0600); err != nil {
+				return err
+			}
+			return os.Chown(path, 12345, 12345)
+		}, named_pipe_ipc.UnexpectedOwner{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chroot := t.TempDir()
+			if err := c.prepare(filepath.Join(chroot, "golang.pipe.1.r")); err != nil {
+				t.Fatal(err)
+			}
+
+			server, err := named_pipe_ipc.NewContext(context.Background(), chroot, named_pipe_ipc.S)
+			if err != c.err {
+				t.Fatalf("expected %v, got %v", c.err, err)
+			}
+			if err == nil {
+				server.Close()
+			}
+		})
+	}
+}
+
+func TestExpectedOwner(t *testing.T) {
+	chroot := t.TempDir()
+	server, err := named_pipe_ipc.NewContext(context.Background(), chroot, named_pipe_ipc.S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if _, err = named_pipe_ipc.NewContext(context.Background(), chroot, named_pipe_ipc.C, named_pipe_ipc.WithExpectedOwner(os.Geteuid()+1)); err != (named_pipe_ipc.UnexpectedOwner{}) {
+		t.Fatalf("expected UnexpectedOwner, got %v", err)
+	}
+
+	client, err := named_pipe_ipc.NewContext(context.Background(), chroot, named_pipe_ipc.C, named_pipe_ipc.WithExpectedOwner(os.Geteuid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+}
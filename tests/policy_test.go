@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// syncBuffer is a bytes.Buffer the logger of a Context writes to while a test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPolicy(t *testing.T) {
+	policy := named_pipe_ipc.Rules{
+		{Subjects: []string{"admin"}},
+		{Subjects: []string{"reader"}, Methods: []string{"orders/delete"}, Deny: true},
+		{Subjects: []string{"reader"}, Methods: []string{"orders/*"}},
+		{Subjects: []string{"reader"}, Topics: []string{"news"}},
+	}
+
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var audit syncBuffer
+	server, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.S,
+		named_pipe_ipc.WithAuthenticator(named_pipe_ipc.Tokens{"a": "admin", "r": "reader"}),
+		named_pipe_ipc.WithEncryption(nil),
+		named_pipe_ipc.WithPolicy(policy),
+		named_pipe_ipc.WithLogger(slog.NewJSONHandler(&audit, nil)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve(named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		return message.Payload(), nil
+	}))
+
+	cases := []struct {
+		token   string
+		headers named_pipe_ipc.Metadata
+		allowed bool
+	}{
+		{"a", named_pipe_ipc.Metadata{named_pipe_ipc.HeaderMethod: "orders/delete"}, true},
+		{"r", named_pipe_ipc.Metadata{named_pipe_ipc.HeaderMethod: "orders/get"}, true},
+		{"r", named_pipe_ipc.Metadata{named_pipe_ipc.HeaderMethod: "orders/delete"}, false},
+		{"r", named_pipe_ipc.Metadata{named_pipe_ipc.HeaderMethod: "users/get"}, false},
+		{"r", named_pipe_ipc.Metadata{named_pipe_ipc.HeaderTopic: "news"}, true},
+		{"r", nil, false},
+	}
+
+	for _, c := range cases {
+		client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithEncryption(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Connect(named_pipe_ipc.Credentials{Token: c.token}); err != nil {
+			t.Fatal(err)
+		}
+
+		reply, err := client.CallContext(named_pipe_ipc.ContextWithHeaders(ctx, c.headers), named_pipe_ipc.Message("nihao"))
+		if c.allowed && (err != nil || reply.Payload().String() != "nihao") {
+			t.Fatalf("%s %v: unexpected reply %v %v", c.token, c.headers, reply, err)
+		}
+		if !c.allowed && err != (named_pipe_ipc.PermissionDenied{}) {
+			t.Fatalf("%s %v: expected PermissionDenied, got %v %v", c.token, c.headers, reply, err)
+		}
+	}
+
+	if denied := server.Stats().PermissionDenied; denied != 3 {
+		t.Fatalf("expected 3 denied frames, got %d", denied)
+	}
+	if !strings.Contains(audit.String(), `"audit":{"client":`) || !strings.Contains(audit.String(), `"method":"users/get"`) {
+		t.Fatalf("denied frames not audited:\n%s", audit.String())
+	}
+}
+
+func TestPolicyUid(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the owner of the FIFOs is not the client which writes to them
+	if _, err := named_pipe_ipc.NewContext(ctx, t.TempDir(), named_pipe_ipc.S, named_pipe_ipc.WithPolicy(named_pipe_ipc.Rules{{Uids: []int{os.Geteuid()}}})); err != (named_pipe_ipc.UnverifiedUid{}) {
+		t.Fatalf("expected UnverifiedUid, got %v", err)
+	}
+	if _, err := named_pipe_ipc.NewContext(ctx, t.TempDir(), named_pipe_ipc.S, named_pipe_ipc.WithPolicy(uidPolicy{})); err != (named_pipe_ipc.UnverifiedUid{}) {
+		t.Fatalf("expected UnverifiedUid, got %v", err)
+	}
+
+	// a Policy which does not use the uid sees an unknown uid and the owner of the FIFOs
+	chroot := t.TempDir()
+	identities := make(chan named_pipe_ipc.Identity, 1)
+	server, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.S, named_pipe_ipc.WithPolicy(named_pipe_ipc.PolicyFunc(func(identity named_pipe_ipc.Identity, request named_pipe_ipc.Request) bool {
+		select {
+		case identities <- identity:
+		default:
+		}
+		return true
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve(named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		return message.Payload(), nil
+	}))
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Call(named_pipe_ipc.Message("nihao")); err != nil {
+		t.Fatal(err)
+	}
+	if identity := <-identities; identity.Uid != -1 || identity.Owner != os.Geteuid() {
+		t.Fatalf("expected the uid -1 and the owner %d with the FIFOs, got %+v", os.Geteuid(), identity)
+	}
+}
+
+// uidPolicy is a Policy other than Rules which matches the uid
+type uidPolicy struct{}
+
+func (uidPolicy) Allow(identity named_pipe_ipc.Identity, request named_pipe_ipc.Request) bool {
+	return identity.Uid == os.Geteuid()
+}
+
+func (uidPolicy) UsesUid() bool {
+	return true
+}
+
+func TestPolicyOwner(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, c := range []struct {
+		owner   int
+		allowed bool
+	}{{os.Geteuid(), true}, {os.Geteuid() + 1, false}} {
+		chroot := t.TempDir()
+		server := echoServer(t, ctx, chroot, named_pipe_ipc.WithPolicy(named_pipe_ipc.Rules{{Owners: []int{c.owner}}}))
+		client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reply, err := client.Call(named_pipe_ipc.Message("nihao"))
+		if c.allowed && (err != nil || reply.Payload().String() != "nihao") {
+			t.Fatalf("owner %d: unexpected reply %v %v", c.owner, reply, err)
+		}
+		if !c.allowed && err != (named_pipe_ipc.PermissionDenied{}) {
+			t.Fatalf("owner %d: expected PermissionDenied, got %v %v", c.owner, reply, err)
+		}
+		client.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	uuid2 "github.com/satori/go.uuid"
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// macFrame build a version 1 frame with a MAC, byteLength count the delim
+func macFrame(payload string, key []byte, nonce []byte, delim byte) []byte {
+	buf := make([]byte, 8)
+	buf = append(buf, protoFlag...)
+	buf = append(buf, 1, 1<<3, protoNormalType)
+	buf = append(buf, uuid2.NewV4().Bytes()...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(time.Now().Unix()+10))
+	buf = append(buf, 1, 0, 0)
+	buf = append(buf, payload...)
+	buf = append(buf, nonce...)
+	binary.BigEndian.PutUint64(buf, uint64(len(buf)+sha256.Size+1))
+	h := hmac.New(sha256.New, key)
+	h.Write(buf)
+	buf = h.Sum(buf)
+
+	return append(buf, delim)
+}
+
+func TestSharedSecret(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Setenv("NAMED_PIPE_IPC_SECRET", " s3cr3t\n")
+	key, err := named_pipe_ipc.SharedSecretFromEnv("NAMED_PIPE_IPC_SECRET")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handled := make(chan string, 10)
+	server := serveWith(t, ctx, chroot, named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		handled <- message.Payload().String()
+		if message.Payload().String() == "noreply" {
+			return nil, nil
+		}
+		return message.Payload(), nil
+	}), named_pipe_ipc.WithSharedSecret(key))
+	defer server.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithSharedSecret(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := client.Call(named_pipe_ipc.Message("nihao")); err != nil || reply.Payload().String() != "nihao" {
+		t.Fatalf("unexpected reply %v %v", reply, err)
+	}
+	<-handled
+
+	pipe, err := os.OpenFile(filepath.Join(chroot, server.NamedPipeForRead()), os.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+
+	nonce := make([]byte, 16)
+	forged := macFrame("noreply", []byte("guess"), nonce, '\n')
+	valid := macFrame("noreply", key, nonce, '\n')
+	for _, frame := range [][]byte{forged, checksumFrame("noreply", '\n'), valid, valid} {
+		if _, err = pipe.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if reply, err := client.Call(named_pipe_ipc.Message("again")); err != nil || reply.Payload().String() != "again" {
+		t.Fatalf("unexpected reply %v %v", reply, err)
+	}
+	if p := <-handled; p != "noreply" {
+		t.Errorf("valid frame not handled, got %q", p)
+	}
+	if p := <-handled; p != "again" {
+		t.Errorf("replayed frame handled, got %q", p)
+	}
+	if n := server.Stats().BadMAC; n != 3 {
+		t.Errorf("unexpected bad mac %d", n)
+	}
+}
+
+// shortFrame build the smallest frame, with the frame flags flags and no trailer behind its empty metadata
+func shortFrame(flags byte) []byte {
+	buf := make([]byte, 8)
+	buf = append(buf, protoFlag...)
+	buf = append(buf, 1, flags, protoNormalType)
+	buf = append(buf, uuid2.NewV4().Bytes()...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(time.Now().Unix()+10))
+	buf = append(buf, 1, 0, 0)
+	binary.BigEndian.PutUint64(buf, uint64(len(buf)+1))
+
+	return append(buf, '\n')
+}
+
+func TestMACTooShort(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := echoServer(t, ctx, chroot)
+	defer server.Close()
+
+	// a MAC flag without room for the MAC, the server has no secret and only removes it
+	frame := shortFrame(1 << 3)
+	writeFifo(t, server, frame)
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := client.Call(named_pipe_ipc.Message("nihao")); err != nil || reply.Payload().String() != "nihao" {
+		t.Fatalf("unexpected reply %v %v", reply, err)
+	}
+	if server.Stats().Malformed == 0 {
+		t.Fatal("the frame was not dropped")
+	}
+
+	// the same frame received by a recorded server
+	capture := append([]byte("NPIPECAP"), 1, 'S', '\n')
+	capture = binary.BigEndian.AppendUint64(capture, uint64(time.Now().UnixNano()))
+	capture = append(capture, byte(named_pipe_ipc.RecordReceived))
+	capture = binary.BigEndian.AppendUint32(capture, uint32(len(frame)-1))
+	capture = append(capture, frame[:len(frame)-1]...)
+	c, err := named_pipe_ipc.ReadCapture(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Replay(ctx, client, 0); err == nil {
+		t.Fatal("expected the malformed request to fail the replay")
+	}
+}
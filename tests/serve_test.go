@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestServe(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.S)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+			if message.Payload().String() == "panic" {
+				panic("boom")
+			}
+			return named_pipe_ipc.Message(strings.ToUpper(message.Payload().String())), nil
+		}), named_pipe_ipc.WithWorkers(4), named_pipe_ipc.WithClientOrdering())
+	}()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.Send(named_pipe_ipc.Message("nihao")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := client.Recv(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Payload().String() != "NIHAO" {
+		t.Errorf("unexpected reply %q", msg.Payload().String())
+	}
+
+	if _, err = client.Send(named_pipe_ipc.Message("panic")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Recv(true)
+	if err == nil || !strings.HasPrefix(err.Error(), named_pipe_ipc.HandlerPanicMessage) {
+		t.Errorf("expect handler panic, got %v", err)
+	}
+
+	if err = server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Error(err)
+	}
+}
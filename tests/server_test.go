@@ -0,0 +1,40 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+// serveWith start a server under chroot which serves handler, the test closes it
+func serveWith(t testing.TB, ctx context.Context, chroot string, handler named_pipe_ipc.Handler, opts ...named_pipe_ipc.Option) *named_pipe_ipc.Context {
+	t.Helper()
+	server, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.S, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(handler)
+
+	return server
+}
+
+// echoServer start a server under chroot which replies the payloads
+func echoServer(t testing.TB, ctx context.Context, chroot string, opts ...named_pipe_ipc.Option) *named_pipe_ipc.Context {
+	t.Helper()
+	return serveWith(t, ctx, chroot, named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		return message.Payload(), nil
+	}), opts...)
+}
+
+// upperServer serve the upper case of the payloads, and an error for "fail"
+func upperServer(t testing.TB, ctx context.Context, chroot string, opts ...named_pipe_ipc.Option) *named_pipe_ipc.Context {
+	t.Helper()
+	return serveWith(t, ctx, chroot, named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		if message.Payload().String() == "fail" {
+			return nil, named_pipe_ipc.RemoteError{Message: "failed"}
+		}
+		return named_pipe_ipc.Message(strings.ToUpper(message.Payload().String())), nil
+	}), opts...)
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestSharedMemory(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// a small ring, so that the frames wrap around and the writer waits for the reader
+	server := echoServer(t, ctx, chroot, named_pipe_ipc.WithTransport(named_pipe_ipc.SharedMemory("ipc.shm", 4096)))
+	defer server.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithTransport(named_pipe_ipc.SharedMemory("ipc.shm", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		payload := fmt.Sprintf("message %d %s", i, strings.Repeat("x", i*10))
+		reply, err := client.Call(named_pipe_ipc.Message(payload))
+		if err != nil || reply.Payload().String() != payload {
+			t.Fatalf("unexpected reply %v %v", reply, err)
+		}
+	}
+
+	if _, err = client.Send(named_pipe_ipc.Message(strings.Repeat("x", 5000))); err != (named_pipe_ipc.TooLarge{}) {
+		t.Fatalf("expected TooLarge, got %v", err)
+	}
+
+	if _, err = named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithTransport(named_pipe_ipc.SharedMemory("ipc.shm", 0))); err != (named_pipe_ipc.SharedMemoryBusy{}) {
+		t.Fatalf("expected SharedMemoryBusy, got %v", err)
+	}
+
+	// once the client is closed another one may attach
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	client, err = named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithTransport(named_pipe_ipc.SharedMemory("ipc.shm", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if reply, err := client.Call(named_pipe_ipc.Message("nihao")); err != nil || reply.Payload().String() != "nihao" {
+		t.Fatalf("unexpected reply %v %v", reply, err)
+	}
+}
+
+// BenchmarkTransport compare the Calls of a client over each transport, on the sizes of BenchmarkCall
+func BenchmarkTransport(b *testing.B) {
+	transports := []struct {
+		name      string
+		transport func() named_pipe_ipc.Transport
+	}{
+		{"fifo", nil},
+		{"unix", func() named_pipe_ipc.Transport { return named_pipe_ipc.UnixSocket("ipc.sock") }},
+		{"shm", func() named_pipe_ipc.Transport { return named_pipe_ipc.SharedMemory("ipc.shm", 0) }},
+	}
+
+	for _, tr := range transports {
+		for _, size := range benchSizes {
+			b.Run(fmt.Sprintf("%s/size=%d", tr.name, size), func(b *testing.B) {
+				chroot := b.TempDir()
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				var serverOpts, clientOpts []named_pipe_ipc.Option
+				if tr.transport != nil {
+					serverOpts = append(serverOpts, named_pipe_ipc.WithTransport(tr.transport()))
+					clientOpts = append(clientOpts, named_pipe_ipc.WithTransport(tr.transport()))
+				}
+				server := echoServer(b, ctx, chroot, serverOpts...)
+				defer server.Close()
+				client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, clientOpts...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer client.Close()
+
+				payload := named_pipe_ipc.Message(strings.Repeat("x", size))
+				b.SetBytes(int64(2 * size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := client.Call(payload); err != nil {
+						b.Fatal(err)
+					}
+				}
+				// closing the shared memory waits for the read in progress
+				b.StopTimer()
+			})
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestMaxMessageSize(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := echoServer(t, ctx, chroot, named_pipe_ipc.WithMaxMessageSize(256))
+	defer server.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.Call(named_pipe_ipc.Message(strings.Repeat("x", 64<<10))); err == nil || err.Error() != named_pipe_ipc.TooLargeMessage {
+		t.Errorf("expect too large, got %v", err)
+	}
+	reply, err := client.Call(named_pipe_ipc.Message("nihao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Payload().String() != "nihao" {
+		t.Errorf("unexpected reply %q", reply.Payload().String())
+	}
+	if n := server.Stats().TooLarge; n != 1 {
+		t.Errorf("unexpected too large %d", n)
+	}
+
+	limited, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithMaxMessageSize(256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = limited.Send(named_pipe_ipc.Message(strings.Repeat("x", 256))); err == nil || err.Error() != named_pipe_ipc.TooLargeMessage {
+		t.Errorf("expect too large, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestStats(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := echoServer(t, ctx, chroot)
+	defer server.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = client.Call(named_pipe_ipc.Message("nihao")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := client.Stats()
+	if stats.FramesSent['0'] != 3 || stats.FramesReceived['1'] != 3 {
+		t.Errorf("unexpected frames %v %v", stats.FramesSent, stats.FramesReceived)
+	}
+	if stats.CallLatency.Count != 3 {
+		t.Errorf("unexpected call count %d", stats.CallLatency.Count)
+	}
+	if stats.BytesSent == 0 || stats.BytesReceived == 0 {
+		t.Error("bytes not counted")
+	}
+
+	var buf bytes.Buffer
+	if err = stats.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`named_pipe_ipc_frames_sent_total{type="normal"} 3`,
+		`named_pipe_ipc_frames_received_total{type="response"} 3`,
+		`named_pipe_ipc_call_latency_seconds_bucket{le="+Inf"} 3`,
+		`named_pipe_ipc_call_latency_seconds_count 3`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, buf.String())
+		}
+	}
+
+	if server.Stats().FramesReceived['0'] != 3 {
+		t.Errorf("unexpected server frames %v", server.Stats().FramesReceived)
+	}
+}
@@ -0,0 +1,93 @@
+// Command ipcclient is a client of the integration tests, driven by the commands it reads on stdin
+//
+//	call <n> <size>   Call n payloads of size bytes, "<id>-<i>|" padded with x, and check each reply is its own
+//	sleep <duration>  Call a payload the server echoes after duration
+//	close             Close the Context
+//
+// It prints a line for every command, "ok ..." or "error ...". It exits at the end of stdin without closing
+// the Context, a client which closes it removes the FIFOs of the server.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func main() {
+	chroot := flag.String("chroot", "", "directory of the FIFOs")
+	id := flag.String("id", strconv.Itoa(os.Getpid()), "tag of the payloads")
+	timeout := flag.Duration("timeout", 0, "lifetime of the Context, 0 for none")
+	flag.Parse()
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	client, err := named_pipe_ipc.NewContext(ctx, *chroot, named_pipe_ipc.C)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println("ready")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "call":
+			n, _ := strconv.Atoi(args[1])
+			size, _ := strconv.Atoi(args[2])
+			fmt.Println(calls(client, *id, n, size))
+		case "sleep":
+			start := time.Now()
+			if _, err := client.Call(named_pipe_ipc.Message("sleep:" + args[1] + "|" + *id)); err != nil {
+				fmt.Printf("error %v after %v\n", err, time.Since(start).Round(time.Millisecond))
+			} else {
+				fmt.Println("ok")
+			}
+		case "close":
+			if err := client.Close(); err != nil {
+				fmt.Println("error", err)
+			} else {
+				fmt.Println("ok")
+			}
+		default:
+			fmt.Println("error unknown command", args[0])
+		}
+	}
+}
+
+// calls Call n payloads and check every reply is the echo of its request
+func calls(client *named_pipe_ipc.Context, id string, n, size int) string {
+	for i := 0; i < n; i++ {
+		payload := []byte(id + "-" + strconv.Itoa(i) + "|")
+		for len(payload) < size {
+			payload = append(payload, 'x')
+		}
+
+		reply, err := client.Call(payload)
+		if err != nil {
+			return fmt.Sprintf("error call %d: %v", i, err)
+		}
+		if !bytes.Equal(reply.Payload(), payload) {
+			return fmt.Sprintf("error call %d: got the reply %.40q", i, reply.Payload())
+		}
+	}
+
+	return "ok " + strconv.Itoa(n)
+}
@@ -0,0 +1,49 @@
+// Command ipcserver is the server of the integration tests, it echoes the payloads back
+//
+// A payload "sleep:<duration>|..." is echoed after the duration. It prints "ready" once its FIFOs are open,
+// and on SIGTERM it closes the Context, which removes them, and prints "closed".
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func main() {
+	chroot := flag.String("chroot", "", "directory of the FIFOs")
+	workers := flag.Int("workers", 4, "handlers running at once")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+
+	server, err := named_pipe_ipc.NewContext(ctx, *chroot, named_pipe_ipc.S)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println("ready")
+
+	server.Serve(named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		payload := message.Payload()
+		if rest, ok := strings.CutPrefix(payload.String(), "sleep:"); ok {
+			d, _ := time.ParseDuration(strings.SplitN(rest, "|", 2)[0])
+			time.Sleep(d)
+		}
+		return payload, nil
+	}), named_pipe_ipc.WithWorkers(*workers))
+
+	if err = server.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println("closed")
+}
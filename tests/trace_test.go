@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestTracePropagation(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	propagator := named_pipe_ipc.WithTracePropagator(named_pipe_ipc.W3CPropagator{})
+	server := serveWith(t, ctx, chroot, named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		tc, _ := named_pipe_ipc.TraceFromContext(ctx)
+		return named_pipe_ipc.Message(tc.TraceParent + "|" + tc.TraceState + "|" + message.Payload().String()), nil
+	}), propagator)
+	defer server.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, propagator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := named_pipe_ipc.TraceContext{
+		TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		TraceState:  "congo=t61rcWkgMzE",
+	}
+	reply, err := client.CallContext(named_pipe_ipc.ContextWithTrace(ctx, tc), named_pipe_ipc.Message("nihao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Payload().String() != tc.TraceParent+"|"+tc.TraceState+"|nihao" {
+		t.Errorf("unexpected reply %q", reply.Payload().String())
+	}
+
+	reply, err = client.Call(named_pipe_ipc.Message("nihao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Payload().String() != "||nihao" {
+		t.Errorf("unexpected reply %q", reply.Payload().String())
+	}
+}
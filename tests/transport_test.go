@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestUnixSocket(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// a socket left by a server which did not remove it is reused
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(chroot, "ipc.sock"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	if err = os.Chmod(filepath.Join(chroot, "ipc.sock"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.S,
+		named_pipe_ipc.WithTransport(named_pipe_ipc.UnixSocket("ipc.sock")),
+		named_pipe_ipc.WithPolicy(named_pipe_ipc.Rules{{Uids: []int{os.Geteuid()}}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		return message.Payload(), nil
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C,
+				named_pipe_ipc.WithTransport(named_pipe_ipc.UnixSocket(filepath.Join(chroot, "ipc.sock"))),
+				named_pipe_ipc.WithExpectedOwner(os.Geteuid()),
+			)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer client.Close()
+
+			for j := 0; j < 20; j++ {
+				payload := fmt.Sprintf("client %d message %d", i, j)
+				reply, err := client.Call(named_pipe_ipc.Message(payload))
+				if err != nil || reply.Payload().String() != payload {
+					t.Errorf("unexpected reply %v %v", reply, err)
+					return
+				}
+			}
+			if n := client.Stats().Retransmissions; n != 0 {
+				t.Errorf("client retransmitted %d frames", n)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, err = named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C,
+		named_pipe_ipc.WithTransport(named_pipe_ipc.UnixSocket("ipc.sock")),
+		named_pipe_ipc.WithExpectedOwner(os.Geteuid()+1),
+	); err != (named_pipe_ipc.UnexpectedOwner{}) {
+		t.Fatalf("expected UnexpectedOwner, got %v", err)
+	}
+
+	if err = server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(chroot, "ipc.sock")); !os.IsNotExist(err) {
+		t.Fatalf("socket not removed: %v", err)
+	}
+}
+
+func TestUnixSocketPermissive(t *testing.T) {
+	chroot := t.TempDir()
+	path := filepath.Join(chroot, "ipc.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	if err = os.Chmod(path, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = named_pipe_ipc.NewContext(context.Background(), chroot, named_pipe_ipc.S, named_pipe_ipc.WithTransport(named_pipe_ipc.UnixSocket("ipc.sock")))
+	if err != (named_pipe_ipc.PermissiveMode{}) {
+		t.Fatalf("expected PermissiveMode, got %v", err)
+	}
+}
+
+func TestUnixSocketHijack(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var handled []string
+	server := serveWith(t, ctx, chroot, named_pipe_ipc.HandlerFunc(func(ctx context.Context, message named_pipe_ipc.Message) (named_pipe_ipc.Message, error) {
+		mu.Lock()
+		handled = append(handled, message.Payload().String())
+		mu.Unlock()
+		return message.Payload(), nil
+	}), named_pipe_ipc.WithTransport(named_pipe_ipc.UnixSocket("ipc.sock")))
+	defer server.Close()
+
+	victim, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithTransport(named_pipe_ipc.UnixSocket("ipc.sock")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer victim.Close()
+	reply, err := victim.Call(named_pipe_ipc.Message("nihao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := named_pipe_ipc.Inspect(reply).ClientID
+
+	// another connection sends a frame with the uuid of the victim to get its replies
+	request := capture(t, func(client *named_pipe_ipc.Context) {
+		client.Send(named_pipe_ipc.Message("spoofed"))
+	})
+	thief, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: filepath.Join(chroot, "ipc.sock"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thief.Close()
+	if _, err = thief.Write(spoofed(request, id)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if reply, ok := callWithin(victim, named_pipe_ipc.Message("shijie"), 2*time.Second); !ok || reply.Payload().String() != "shijie" {
+		t.Fatalf("the reply of the victim was not routed to it: %v", reply)
+	}
+	thief.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := thief.Read(make([]byte, 1024)); n != 0 || err == nil {
+		t.Fatalf("the thief got %d bytes", n)
+	}
+	if server.Stats().Malformed == 0 {
+		t.Fatal("the frame of the thief was not dropped")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, payload := range handled {
+		if payload == "spoofed" {
+			t.Fatal("the frame of the thief was handled")
+		}
+	}
+}
+
+func TestUnixSocketAuthenticator(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// a UnixSocket pins the uuid of a client to its connection, the sessions need no encryption
+	server := echoServer(t, ctx, chroot,
+		named_pipe_ipc.WithTransport(named_pipe_ipc.UnixSocket("ipc.sock")),
+		named_pipe_ipc.WithAuthenticator(named_pipe_ipc.Tokens{"t0ken": "worker"}),
+	)
+	defer server.Close()
+
+	for _, c := range []struct {
+		token string
+		err   error
+	}{{"t0ken", nil}, {"wrong", named_pipe_ipc.Unauthenticated{}}} {
+		client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithTransport(named_pipe_ipc.UnixSocket(filepath.Join(chroot, "ipc.sock"))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Connect(named_pipe_ipc.Credentials{Token: c.token}); err != c.err {
+			t.Fatalf("%s: expected %v, got %v", c.token, c.err, err)
+		}
+
+		_, err = client.Call(named_pipe_ipc.Message("nihao"))
+		if c.err == nil && err != nil {
+			t.Fatal(err)
+		}
+		if c.err != nil && err != (named_pipe_ipc.Unauthenticated{}) {
+			t.Fatalf("%s: expected Unauthenticated, got %v", c.token, err)
+		}
+		client.Close()
+	}
+}
@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	uuid2 "github.com/satori/go.uuid"
+	named_pipe_ipc "github.com/whiteCcinn/named-pipe-ipc"
+)
+
+func TestHandshake(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := echoServer(t, ctx, chroot, named_pipe_ipc.WithFeatures("codec/json", "codec/msgpack"))
+	defer server.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C, named_pipe_ipc.WithFeatures("codec/msgpack", "codec/cbor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capabilities, err := client.Handshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capabilities.Version != 1 {
+		t.Errorf("unexpected version %d", capabilities.Version)
+	}
+	if len(capabilities.Features) != 1 || !capabilities.Has("codec/msgpack") {
+		t.Errorf("unexpected features %v", capabilities.Features)
+	}
+
+	reply, err := client.Call(named_pipe_ipc.Message("nihao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Payload().String() != "nihao" {
+		t.Errorf("unexpected reply %q", reply.Payload().String())
+	}
+}
+
+// baselineFrame build a frame the way a client before the version byte sent it
+func baselineFrame(t byte, client uuid2.UUID, ttl int64, content string) []byte {
+	frame := binary.BigEndian.AppendUint64(nil, uint64(8+len(protoFlag)+1+16+8+len(content)+1))
+	frame = append(frame, protoFlag...)
+	frame = append(frame, t)
+	frame = append(frame, client.Bytes()...)
+	frame = binary.BigEndian.AppendUint64(frame, uint64(ttl))
+	frame = append(frame, content...)
+
+	return append(frame, '\n')
+}
+
+func TestBaselineFrame(t *testing.T) {
+	id := uuid2.NewV4()
+	ttl := time.Now().Unix() + 10
+
+	// an empty content makes the shortest frame of that layout
+	stream := append(baselineFrame('0', id, ttl, "nihao"), baselineFrame('2', id, ttl, "")...)
+	decoder := named_pipe_ipc.NewDecoder(bytes.NewReader(stream), '\n')
+	for _, want := range []struct {
+		typeName string
+		payload  string
+	}{{"normal", "nihao"}, {"retran", ""}} {
+		frame, _, err := decoder.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi := named_pipe_ipc.Inspect(frame)
+		if fi.Version != 0 || fi.TypeName != want.typeName || fi.ClientID != id || fi.TTL.Unix() != ttl || fi.Payload.String() != want.payload || fi.Problem != named_pipe_ipc.IncompatibleVersionMessage {
+			t.Fatalf("unexpected frame info %+v", fi)
+		}
+	}
+	if _, _, err := decoder.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := echoServer(t, ctx, chroot)
+	defer server.Close()
+
+	// a frame sent back by an old client gets no reply, its request gets one it reads
+	reader, err := os.OpenFile(filepath.Join(chroot, server.NamedPipeForWrite()), os.O_RDWR, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	other := uuid2.NewV4()
+	writeFifo(t, server, baselineFrame('2', other, ttl, "nihao"), baselineFrame('0', id, ttl, "nihao"))
+
+	want := baselineFrame('1', id, ttl, named_pipe_ipc.IncompatibleVersionMessage)
+	reader.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply := make([]byte, len(want))
+	if _, err = io.ReadFull(reader, reply); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply, want) {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	if malformed := server.Stats().Malformed; malformed != 2 {
+		t.Fatalf("expected 2 dropped frames, got %d", malformed)
+	}
+}
+
+func TestHandshakeBaselineServer(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// the FIFOs of a server which does not listen, a server before the version byte answers in its place
+	server, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	requests, err := os.OpenFile(filepath.Join(chroot, server.NamedPipeForRead()), os.O_RDWR, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer requests.Close()
+	replies, err := os.OpenFile(filepath.Join(chroot, server.NamedPipeForWrite()), os.O_RDWR, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replies.Close()
+
+	client, err := named_pipe_ipc.NewContext(ctx, chroot, named_pipe_ipc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Handshake()
+		done <- err
+	}()
+
+	const uuidOffset = 8 + 14 + 1 + 1 + 1
+	hello := readFrame(t, requests)
+	id, err := uuid2.FromBytes(hello[uuidOffset : uuidOffset+16])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = replies.Write(baselineFrame('1', id, time.Now().Unix()+10, named_pipe_ipc.IncompatibleVersionMessage)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err = <-done:
+		if _, ok := err.(named_pipe_ipc.IncompatibleVersion); !ok {
+			t.Fatalf("expected IncompatibleVersion, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the handshake did not end on the reply of a baseline server")
+	}
+}
+
+// readFrame read the next frame the server wrote to r, the delim included
+func readFrame(t *testing.T, r *os.File) []byte {
+	t.Helper()
+	r.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame := make([]byte, 8)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		t.Fatal(err)
+	}
+	frame = append(frame, make([]byte, binary.BigEndian.Uint64(frame)-8)...)
+	if _, err := io.ReadFull(r, frame[8:]); err != nil {
+		t.Fatal(err)
+	}
+
+	return frame
+}
+
+func TestRetransmittedHello(t *testing.T) {
+	chroot := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := echoServer(t, ctx, chroot, named_pipe_ipc.WithAuthenticator(named_pipe_ipc.Tokens{"t0ken": "worker"}), named_pipe_ipc.WithEncryption(nil))
+	defer server.Close()
+
+	reader, err := os.OpenFile(filepath.Join(chroot, server.NamedPipeForWrite()), os.O_RDWR, os.ModeNamedPipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	writeFifo(t, server, helloOf(t, named_pipe_ipc.WithEncryption(nil)))
+	reply := readFrame(t, reader)
+
+	// another client read the reply, it sends it back with the retran flag for the server to send it again
+	const frameFlagsOffset = 8 + 14 + 1
+	retransmitted := append([]byte(nil), reply...)
+	retransmitted[frameFlagsOffset] |= 1
+	writeFifo(t, server, retransmitted)
+	if again := readFrame(t, reader); !bytes.Equal(again, reply) {
+		t.Fatalf("the hello reply was not sent again as it came: %q", again)
+	}
+}
@@ -0,0 +1,68 @@
+package named_pipe_ipc
+
+import "context"
+
+const (
+	traceParentKey = "traceparent"
+	traceStateKey  = "tracestate"
+)
+
+// TracePropagator carry the trace of a context.Context across the pipe
+//
+// Inject is called with the context of SendContext/CallContext before the frame is written,
+// Extract is called by Serve and returns the context given to the handler.
+type TracePropagator interface {
+	Inject(ctx context.Context, metadata Metadata)
+	Extract(ctx context.Context, metadata Metadata) context.Context
+}
+
+// WithTracePropagator set the propagator of the trace context
+func WithTracePropagator(propagator TracePropagator) Option {
+	return OptionsFunc(func(o *options) {
+		o.tracePropagator = propagator
+	})
+}
+
+// TraceContext is a W3C trace context, https://www.w3.org/TR/trace-context/
+type TraceContext struct {
+	TraceParent string
+	TraceState  string
+}
+
+type traceContextKey struct{}
+
+// ContextWithTrace return a copy of ctx which carries tc
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceFromContext return the trace context carried by ctx
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// W3CPropagator carry the TraceContext of ContextWithTrace in the traceparent/tracestate metadata
+type W3CPropagator struct {
+}
+
+func (W3CPropagator) Inject(ctx context.Context, metadata Metadata) {
+	tc, ok := TraceFromContext(ctx)
+	if !ok || tc.TraceParent == "" {
+		return
+	}
+
+	metadata[traceParentKey] = tc.TraceParent
+	if tc.TraceState != "" {
+		metadata[traceStateKey] = tc.TraceState
+	}
+}
+
+func (W3CPropagator) Extract(ctx context.Context, metadata Metadata) context.Context {
+	tp, ok := metadata[traceParentKey]
+	if !ok {
+		return ctx
+	}
+
+	return ContextWithTrace(ctx, TraceContext{TraceParent: tp, TraceState: metadata[traceStateKey]})
+}
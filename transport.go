@@ -0,0 +1,53 @@
+package named_pipe_ipc
+
+import (
+	"io"
+)
+
+// Transport carry the frames of a Context
+//
+// A FIFO pair is the default Transport, a Transport with a connection per client (like UnixSocket)
+// writes the frames of the server only to their client, so that the clients never retransmit.
+type Transport interface {
+	// Open is called once by NewContext, when the options of nctx are set
+	Open(nctx *Context) error
+	// Read return the next frame without the delim, and the peer which wrote it
+	Read() (Message, Peer, error)
+	// Write write a frame followed by the delim, on the server to the client of the frame
+	Write(frame []byte) error
+	Close() error
+}
+
+// Peer is the process on the other side of a Transport, -1 when the Transport can not tell
+type Peer struct {
+	Pid int
+	Uid int
+	Gid int
+}
+
+var unknownPeer = Peer{Pid: -1, Uid: -1, Gid: -1}
+
+// verifier is a Transport which tells the uid of its peers from the kernel, not from the owner of a file
+type verifier interface {
+	verifiesPeers() bool
+}
+
+// remover is a Transport which removes what it created when the Context is closed
+type remover interface {
+	remove() error
+}
+
+// WithTransport replace the FIFO pair of the Context by transport, a Transport is used by one Context
+func WithTransport(transport Transport) Option {
+	return OptionsFunc(func(o *options) {
+		o.transport = transport
+	})
+}
+
+// newDecoder return a decoder of the frames of r with the options of the Context
+func (nctx *Context) newDecoder(r io.Reader) *decoder {
+	d := newDecoder(r, nctx.delim, nctx.options.maxFrameLength, nctx.resync)
+	d.maxMessageSize = nctx.options.maxMessageSize
+
+	return d
+}
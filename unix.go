@@ -0,0 +1,277 @@
+package named_pipe_ipc
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	uuid2 "github.com/satori/go.uuid"
+)
+
+// UnixSocket return a Transport over the Unix domain socket name, under the chroot unless name is absolute
+//
+// Every client has its own connection, so the server writes a frame only to its client and the server
+// knows the uid of every client from SO_PEERCRED. A uuid belongs to the first connection which used it until
+// it is closed, the frames of that uuid from other connections are dropped. The socket is created with the mode and the owner of the FIFOs.
+func UnixSocket(name string) Transport {
+	return &unixTransport{name: name}
+}
+
+type unixTransport struct {
+	name string
+	path string
+	nctx *Context
+
+	// client
+	conn    *net.UnixConn
+	decoder *decoder
+	peer    Peer
+
+	// server
+	listener *net.UnixListener
+	frames   chan unixFrame
+	done     chan struct{}
+	once     sync.Once
+	mu       sync.Mutex
+	// routes is the connection of each client, conns every open connection
+	routes map[uuid2.UUID]*net.UnixConn
+	conns  map[*net.UnixConn]struct{}
+}
+
+type unixFrame struct {
+	frame Message
+	peer  Peer
+	err   error
+}
+
+func (t *unixTransport) Open(nctx *Context) error {
+	t.nctx = nctx
+	t.path = t.name
+	if !filepath.IsAbs(t.path) {
+		t.path = nctx.chroot + t.name
+	}
+
+	if nctx.role == S {
+		return t.listen()
+	}
+
+	return t.dial()
+}
+
+func (t *unixTransport) listen() error {
+	nctx := t.nctx
+	if s, err := os.Lstat(t.path); err == nil {
+		// the socket of a server which did not remove it, only reused when it is as private as ours
+		if s.Mode().Type() != os.ModeSocket {
+			return AlreadyExistButNotSocket{}
+		}
+		uid, gid := nctx.options.ownerOf()
+		if err = nctx.verifyOwner(t.path, s, uid, gid); err != nil {
+			return err
+		}
+		if err = os.Remove(t.path); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: t.path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	listener.SetUnlinkOnClose(false)
+	if err = nctx.setOwner(t.path); err != nil {
+		listener.Close()
+		return err
+	}
+
+	t.listener = listener
+	t.frames = make(chan unixFrame)
+	t.done = make(chan struct{})
+	t.routes = make(map[uuid2.UUID]*net.UnixConn)
+	t.conns = make(map[*net.UnixConn]struct{})
+	go t.accept()
+
+	nctx.logger.Debug("unix socket listening", slog.String("path", t.path))
+	return nil
+}
+
+func (t *unixTransport) dial() error {
+	nctx := t.nctx
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: t.path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+
+	t.peer = peerCredentials(conn)
+	if nctx.options.expectedOwner != anyOwner && t.peer.Uid != nctx.options.expectedOwner {
+		nctx.logger.Warn("server of unexpected owner", slog.String("path", t.path), slog.Int("uid", t.peer.Uid))
+		conn.Close()
+		return UnexpectedOwner{}
+	}
+
+	t.conn = conn
+	t.decoder = nctx.newDecoder(conn)
+
+	nctx.logger.Debug("unix socket connected", slog.String("path", t.path), slog.Int("pid", t.peer.Pid))
+	return nil
+}
+
+func (t *unixTransport) accept() {
+	for {
+		conn, err := t.listener.AcceptUnix()
+		if err != nil {
+			if !isClosed(err) {
+				t.nctx.logger.Warn("unix socket accept failed", slog.Any("error", err))
+			}
+			return
+		}
+
+		t.mu.Lock()
+		t.conns[conn] = struct{}{}
+		t.mu.Unlock()
+		go t.serve(conn)
+	}
+}
+
+// serve read the frames of conn until it is closed
+func (t *unixTransport) serve(conn *net.UnixConn) {
+	peer := peerCredentials(conn)
+	d := t.nctx.newDecoder(conn)
+	t.nctx.logger.Debug("unix socket accepted", slog.Int("pid", peer.Pid), slog.Int("uid", peer.Uid))
+
+	defer t.drop(conn)
+	for {
+		frame, err := d.next()
+		routed := frame
+		if tl, ok := err.(frameTooLarge); ok {
+			// the client get the TooLarge reply on this connection
+			routed = tl.header
+		} else if err != nil {
+			return
+		}
+		if !t.route(routed, conn) {
+			t.nctx.stats.malformed.Add(1)
+			t.nctx.logger.Warn("frame dropped, uuid of another connection", append(frameAttrs(routed), slog.Int("pid", peer.Pid), slog.Int("uid", peer.Uid))...)
+			continue
+		}
+
+		select {
+		case t.frames <- unixFrame{frame, peer, err}:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// route pin the client of frame to conn, the first connection which used its uuid, and tell if conn is its connection
+func (t *unixTransport) route(frame Message, conn *net.UnixConn) bool {
+	uuid, err := frame.segmentUUID()
+	if err != nil {
+		// too short to name a client, the server drops it
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.routes[uuid]; ok {
+		return c == conn
+	}
+	t.routes[uuid] = conn
+
+	return true
+}
+
+// drop close conn and forget the clients which used it
+func (t *unixTransport) drop(conn *net.UnixConn) {
+	conn.Close()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+	for uuid, c := range t.routes {
+		if c == conn {
+			delete(t.routes, uuid)
+		}
+	}
+}
+
+// verifiesPeers tell that the uid of a client comes from SO_PEERCRED
+func (t *unixTransport) verifiesPeers() bool {
+	return true
+}
+
+func (t *unixTransport) Read() (Message, Peer, error) {
+	if t.nctx.role == C {
+		frame, err := t.decoder.next()
+		return frame, t.peer, err
+	}
+
+	select {
+	case f := <-t.frames:
+		return f.frame, f.peer, f.err
+	case <-t.done:
+		return nil, unknownPeer, net.ErrClosed
+	}
+}
+
+func (t *unixTransport) Write(frame []byte) error {
+	if t.nctx.role == C {
+		_, err := t.conn.Write(frame)
+		return err
+	}
+
+	uuid, err := Message(frame).segmentUUID()
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	conn, ok := t.routes[uuid]
+	t.mu.Unlock()
+	if !ok {
+		return UnknownClient{}
+	}
+
+	_, err = conn.Write(frame)
+	return err
+}
+
+func (t *unixTransport) Close() error {
+	if t.nctx.role == C {
+		err := t.conn.Close()
+		if isClosed(err) {
+			return nil
+		}
+		return err
+	}
+
+	var err error
+	t.once.Do(func() {
+		close(t.done)
+		err = t.listener.Close()
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for conn := range t.conns {
+			conn.Close()
+		}
+		t.nctx.logger.Debug("unix socket closed", slog.String("path", t.path))
+	})
+
+	return err
+}
+
+func (t *unixTransport) remove() error {
+	if t.nctx.role != S {
+		return nil
+	}
+
+	err := os.Remove(t.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	t.nctx.logger.Debug("unix socket removed", slog.String("path", t.path))
+
+	return nil
+}
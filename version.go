@@ -0,0 +1,273 @@
+package named_pipe_ipc
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	uuid2 "github.com/satori/go.uuid"
+)
+
+/**
+hello:
+	a client Handshake with a hello frame, the server reply with a hello frame or an error frame.
+	hello frames and the error frame replying to them are always written in version 1,
+	so that peers of every version can read them.
+
+	client headers: min-version, max-version, features, x25519
+	server headers: version, features, x25519
+
+	x25519 is the public key of a side, sent when they agree on encryption without a pre-shared key.
+
+baseline:
+	8byte - 14byte - 1byte - 16byte - 8byte - string
+	byteLength - flag - type - uuid  - ttl - content
+
+	the layout before the version byte, its type '0', '1' or '2' is where the version is now.
+	A server replies IncompatibleVersion to its requests in this layout, which its clients read.
+*/
+
+// baselineHeaderLen is the length of byteLength, flag, type, uuid and ttl in the layout before the version byte
+const baselineHeaderLen = 8 + len(protoFlag) + 1 + 16 + 8
+
+const (
+	helloMinVersionKey = "min-version"
+	helloMaxVersionKey = "max-version"
+	helloVersionKey    = "version"
+	helloFeaturesKey   = "features"
+)
+
+// Capabilities is what the peers of a Handshake agreed on
+type Capabilities struct {
+	Version  byte
+	Features []string
+}
+
+// Has tell if the peers agreed on feature
+func (c Capabilities) Has(feature string) bool {
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithFeatures add features to what the Context offers in a Handshake, such as the codecs of the application
+func WithFeatures(features ...string) Option {
+	return OptionsFunc(func(o *options) {
+		o.features = append(o.features[:len(o.features):len(o.features)], features...)
+	})
+}
+
+// Handshake exchange the capabilities with the server
+//
+// A client should Handshake before it sends, the frames received before the reply of the server are dropped.
+// A server which does not support our version, a baseline server too, replies with IncompatibleVersion. The server answers a client
+// once, a Handshake after it succeeded returns what was agreed.
+func (nctx *Context) Handshake() (Capabilities, error) {
+	if nctx.role != C {
+		return Capabilities{}, NotClientRole{}
+	}
+	nctx.peerMu.RLock()
+	agreed := nctx.peer
+	nctx.peerMu.RUnlock()
+	if agreed.Version != 0 {
+		return agreed, nil
+	}
+
+	v := strconv.Itoa(int(protoVersion))
+	metadata := Metadata{
+		helloMinVersionKey: v,
+		helloMaxVersionKey: v,
+		helloFeaturesKey:   strings.Join(nctx.options.features, ","),
+	}
+	private, err := nctx.offerKeyExchange(metadata)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	hello, err := nctx.frame(protoHelloType, metadata, nil)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	nctx.handshaking.Store(true)
+	defer nctx.handshaking.Store(false)
+	if _, err = nctx.directlySend(hello); err != nil {
+		return Capabilities{}, err
+	}
+
+	for {
+		reply, err := nctx.recv(true)
+		if err != nil {
+			return Capabilities{}, err
+		}
+		if reply.segmentType() != protoHelloType {
+			nctx.logger.Debug("frame dropped during handshake", frameAttrs(reply)...)
+			continue
+		}
+
+		headers := reply.Headers()
+		version, err := strconv.Atoi(headers[helloVersionKey])
+		if err != nil || byte(version) != protoVersion {
+			return Capabilities{}, IncompatibleVersion{}
+		}
+		capabilities := Capabilities{Version: byte(version), Features: splitFeatures(headers[helloFeaturesKey])}
+		if private != nil && capabilities.Has(aeadFeature) {
+			if err = nctx.exchangeKey(nctx.clientID, private, headers); err != nil {
+				return Capabilities{}, err
+			}
+		}
+
+		nctx.peerMu.Lock()
+		nctx.peer = capabilities
+		nctx.peerMu.Unlock()
+
+		nctx.logger.Debug("handshake done", slog.Int("version", version), slog.Any("features", capabilities.Features))
+		return capabilities, nil
+	}
+}
+
+// hello answer the Handshake of a client
+//
+// A client Handshakes once: the uuid of a client is seen by every other client, a second hello could give
+// its replies the key of another one or leave out encryption, it is dropped without a reply.
+func (nctx *Context) hello(frame Message) {
+	uuid, err := frame.segmentUUID()
+	if err != nil {
+		return
+	}
+	if nctx.negotiated(uuid) {
+		nctx.logger.Warn("handshake refused, client already negotiated", frameAttrs(frame)...)
+		return
+	}
+
+	headers := frame.Headers()
+	lo, loErr := strconv.Atoi(headers[helloMinVersionKey])
+	hi, hiErr := strconv.Atoi(headers[helloMaxVersionKey])
+	if loErr != nil || hiErr != nil || int(protoVersion) < lo || int(protoVersion) > hi {
+		nctx.logger.Warn("handshake with incompatible version", append(frameAttrs(frame), slog.String("min", headers[helloMinVersionKey]), slog.String("max", headers[helloMaxVersionKey]))...)
+		_, _ = nctx.Send(frame.ErrorPayload(IncompatibleVersion{}))
+		return
+	}
+
+	offered := splitFeatures(headers[helloFeaturesKey])
+	capabilities := Capabilities{Version: protoVersion}
+	for _, f := range nctx.options.features {
+		for _, o := range offered {
+			if f == o {
+				capabilities.Features = append(capabilities.Features, f)
+				break
+			}
+		}
+	}
+	sort.Strings(capabilities.Features)
+
+	metadata := Metadata{
+		helloVersionKey:  strconv.Itoa(int(capabilities.Version)),
+		helloFeaturesKey: strings.Join(capabilities.Features, ","),
+	}
+	if capabilities.Has(aeadFeature) && nctx.options.encryptionKey == nil {
+		// the client offered its key, the keys of this client are set up before it is told
+		private, err := nctx.offerKeyExchange(metadata)
+		if err == nil {
+			err = nctx.exchangeKey(uuid, private, headers)
+		}
+		if err != nil {
+			nctx.logger.Warn("key exchange failed", append(frameAttrs(frame), slog.Any("error", err))...)
+			_, _ = nctx.Send(frame.ErrorPayload(CannotDecrypt{}))
+			return
+		}
+	}
+
+	nctx.peerMu.Lock()
+	nctx.peers[uuid] = capabilities
+	nctx.peerMu.Unlock()
+
+	_, _ = nctx.Send(frame.reply(protoHelloType, nil, metadata))
+}
+
+// negotiated tell if the client uuid already has capabilities or keys
+func (nctx *Context) negotiated(uuid uuid2.UUID) bool {
+	nctx.peerMu.RLock()
+	defer nctx.peerMu.RUnlock()
+
+	_, ok := nctx.peers[uuid]
+	_, keyed := nctx.aeads[uuid]
+	return ok || keyed
+}
+
+// incompatible reply IncompatibleVersion to a frame of another version when we can tell who sent it
+//
+// It returns IncompatibleVersion to a client whose hello a baseline server answered, its Handshake ends there.
+func (nctx *Context) incompatible(frame Message) error {
+	nctx.stats.malformed.Add(1)
+	if frame.isBaseline() {
+		uuid, _ := frame.baselineUUID()
+		nctx.logger.Warn("frame of baseline version dropped", slog.String("type", string(frame.segmentVersion())), slog.Int("size", len(frame)), slog.String("client", uuid.String()))
+
+		// the other frames are the replies of the server, or our frames sent back by the clients of that version
+		if nctx.role == S && frame.segmentVersion() == protoNormalType {
+			_, _ = nctx.write(frame.baselineReply(Message(IncompatibleVersionMessage)))
+		}
+		if nctx.role == C && uuid == nctx.clientID && nctx.handshaking.Load() {
+			return IncompatibleVersion{}
+		}
+		return nil
+	}
+
+	nctx.logger.Warn("frame of incompatible version dropped", append(frameAttrs(frame), slog.Int("version", int(frame.segmentVersion())), slog.Int("frameFlags", int(frame.segmentFrameFlags())))...)
+	if nctx.role == S {
+		_, _ = nctx.Send(frame.ErrorPayload(IncompatibleVersion{}))
+	}
+	return nil
+}
+
+// isBaseline tell if the frame has the layout before the version byte, its type where the version is now
+func (M Message) isBaseline() bool {
+	v := M.segmentVersion()
+	return v == protoNormalType || v == protoResponseType || v == protoRetranType
+}
+
+func (M Message) baselineUUID() (uuid2.UUID, error) {
+	offset := M.segmentVersionOffset() + 1
+	return uuid2.FromBytes(M[offset : offset+M.segmentUUIDLen()])
+}
+
+func (M Message) baselineTTL() int64 {
+	offset := M.segmentVersionOffset() + 1 + M.segmentUUIDLen()
+	return int64(binary.BigEndian.Uint64(M[offset : offset+M.segmentTTLLen()]))
+}
+
+// baselineReply build the response to M in the layout before the version byte, with the uuid and the ttl of M
+func (M Message) baselineReply(message Message) Message {
+	m := make(Message, 0, baselineHeaderLen+len(message))
+	m = binary.BigEndian.AppendUint64(m, uint64(baselineHeaderLen+len(message)+1))
+	m = append(m, M[M.segmentPackageLengthLen():baselineHeaderLen]...)
+	m[M.segmentVersionOffset()] = protoResponseType
+
+	return append(m, message...)
+}
+
+// peerCapabilities return what the client of frame agreed on with the server, or the server with the client
+func (nctx *Context) peerCapabilities(frame Message) Capabilities {
+	nctx.peerMu.RLock()
+	defer nctx.peerMu.RUnlock()
+
+	if nctx.role == C {
+		return nctx.peer
+	}
+
+	uuid, _ := frame.segmentUUID()
+	return nctx.peers[uuid]
+}
+
+func splitFeatures(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
+}